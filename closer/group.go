@@ -0,0 +1,158 @@
+package closer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/zhiqiangxu/util"
+)
+
+// StrictGroup is an errgroup style Strict closer,
+// goroutines are started by Go and report failure by returning error.
+// The first error or panic cancels the shared context and closes the group,
+// after which Go fails like Add with positive delta after Wait.
+type StrictGroup struct {
+	strict *Strict
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	closed bool
+	errs   Errors
+
+	waitOnce sync.Once
+	waitErr  error
+}
+
+// Errors is all errors returned by the goroutines of StrictGroup, in order of return
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the member errors for errors.Is and errors.As
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// Is reports whether any member error matches target, for Go versions before 1.20
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first member error that matches target, for Go versions before 1.20
+func (e Errors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// PanicError is the error of a goroutine that panicked
+type PanicError struct {
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// NewStrictGroup is ctor for StrictGroup, the shared context is derived from ctx
+func NewStrictGroup(ctx context.Context) *StrictGroup {
+	ctx, cancel := context.WithCancel(ctx)
+	return &StrictGroup{strict: NewStrict(), ctx: ctx, cancel: cancel}
+}
+
+// Context returns the shared context, which is canceled on close
+func (g *StrictGroup) Context() context.Context {
+	return g.ctx
+}
+
+// Go runs f in a new goroutine with the shared context,
+// returns non nil error without running f if the group has been closed.
+func (g *StrictGroup) Go(f func(ctx context.Context) error) (err error) {
+	g.mu.Lock()
+	closed := g.closed
+	g.mu.Unlock()
+	if closed {
+		err = errAlreadyClosed
+		return
+	}
+
+	err = g.strict.Add(1)
+	if err != nil {
+		return
+	}
+
+	go func() {
+		defer g.strict.Done()
+
+		var ferr error
+		util.RunWithRecovery(func() {
+			ferr = f(g.ctx)
+		}, func(r interface{}) {
+			ferr = &PanicError{Value: r}
+		})
+		if ferr != nil {
+			g.fail(ferr)
+		}
+	}()
+	return
+}
+
+// Close cancels the shared context and rejects further Go without waiting
+func (g *StrictGroup) Close() {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+
+	g.cancel()
+}
+
+// Wait blocks until all goroutines return, no more Go will succeed after it's called.
+// It returns Errors if any goroutine failed, later calls return the same.
+func (g *StrictGroup) Wait() error {
+	g.waitOnce.Do(func() {
+		g.strict.SignalAndWait()
+		g.cancel()
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		g.closed = true
+		if len(g.errs) > 0 {
+			g.waitErr = g.errs
+		}
+	})
+	return g.waitErr
+}
+
+// CloseAndWait cancels the shared context and waits for all goroutines
+func (g *StrictGroup) CloseAndWait() error {
+	g.Close()
+	return g.Wait()
+}
+
+func (g *StrictGroup) fail(err error) {
+	g.mu.Lock()
+	g.errs = append(g.errs, err)
+	g.closed = true
+	g.mu.Unlock()
+
+	g.cancel()
+}
@@ -0,0 +1,74 @@
+package closer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStrictGroup(t *testing.T) {
+	// no error
+	g := NewStrictGroup(context.Background())
+	for i := 0; i < 10; i++ {
+		g.Go(func(ctx context.Context) error {
+			time.Sleep(time.Millisecond * 10)
+			return nil
+		})
+	}
+	if g.Wait() != nil || g.Go(func(context.Context) error { return nil }) == nil {
+		t.FailNow()
+	}
+
+	// the first error cancels the others, and rejects further Go
+	errFirst := errors.New("first")
+	g = NewStrictGroup(context.Background())
+	g.Go(func(ctx context.Context) error {
+		return errFirst
+	})
+	g.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	g.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		panic("boom")
+	})
+	<-g.Context().Done()
+	if g.Go(func(context.Context) error { return nil }) == nil {
+		t.FailNow()
+	}
+
+	errs, ok := g.Wait().(Errors)
+	if !ok || len(errs) != 3 || errs[0] != errFirst {
+		t.Fatal(errs)
+	}
+	panics := 0
+	for _, err := range errs {
+		if perr, ok := err.(*PanicError); ok && perr.Value == "boom" {
+			panics++
+		}
+	}
+	if panics != 1 {
+		t.Fatal(errs)
+	}
+	var perr *PanicError
+	if !errors.Is(errs, errFirst) || !errors.Is(errs, context.Canceled) || !errors.As(errs, &perr) {
+		t.Fatal(errs)
+	}
+
+	// Wait again, or after Wait
+	if err := g.CloseAndWait(); len(err.(Errors)) != 3 {
+		t.Fatal(err)
+	}
+
+	// close without error
+	g = NewStrictGroup(context.Background())
+	g.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	if g.CloseAndWait() != nil {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,224 @@
+package closer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tree is a hierarchical closer for composing a service out of components,
+// each node is a Strict closer for the goroutines of one component,
+// plus hooks for closing its resources like servers and queues.
+// Children are closed before their parent, siblings concurrently.
+type Tree struct {
+	name    string
+	timeout time.Duration
+	parent  *Tree
+	strict  *Strict
+
+	mu       sync.Mutex
+	children []*Tree
+	hooks    []func() error
+	closing  bool
+	once     sync.Once
+	report   Report
+}
+
+// Report of SignalAndWait
+type Report struct {
+	Failures []Failure
+}
+
+// Failure is a component failed to stop in time or with error
+type Failure struct {
+	Name   string // path from root, like "root/server/queue"
+	Err    error
+	Stacks string // all goroutines when timed out
+}
+
+// ErrCloseTimeout is the Failure.Err of a component failed to stop in time
+var ErrCloseTimeout = errors.New("close timeout")
+
+// OK tells whether all components stopped in time without error
+func (r Report) OK() bool {
+	return len(r.Failures) == 0
+}
+
+func (r Report) Error() string {
+	var b strings.Builder
+	for _, f := range r.Failures {
+		fmt.Fprintf(&b, "%s: %v\n", f.Name, f.Err)
+	}
+	return b.String()
+}
+
+// NewTree is ctor for the root of Tree
+// timeout bounds closing the node itself excluding its children, 0 means no limit.
+func NewTree(name string, timeout time.Duration) *Tree {
+	return &Tree{name: name, timeout: timeout, strict: NewStrict()}
+}
+
+// NewChild creates a child node, which is closed immediately if t is closing
+func (t *Tree) NewChild(name string, timeout time.Duration) *Tree {
+	child := NewTree(name, timeout)
+	child.parent = t
+
+	t.mu.Lock()
+	closing := t.closing
+	if !closing {
+		t.children = append(t.children, child)
+	}
+	t.mu.Unlock()
+
+	if closing {
+		child.SignalAndWait()
+	}
+	return child
+}
+
+// Name returns the path from root
+func (t *Tree) Name() string {
+	if t.parent == nil {
+		return t.name
+	}
+	return t.parent.Name() + "/" + t.name
+}
+
+// Add delta to the goroutines of the node,
+// trying to Add positive delta after closed will return non nil error
+func (t *Tree) Add(delta int) error {
+	return t.strict.Add(delta)
+}
+
+// Done decrements the goroutines of the node by one
+func (t *Tree) Done() {
+	t.strict.Done()
+}
+
+// ClosedSignal gets signaled when the node starts closing, after its children are closed
+func (t *Tree) ClosedSignal() <-chan struct{} {
+	return t.strict.ClosedSignal()
+}
+
+// OnClose registers f to be called when the node starts closing,
+// hooks are called in reverse order of registration, errors are reported as failures
+func (t *Tree) OnClose(f func() error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.hooks = append(t.hooks, f)
+}
+
+// SignalAndWait closes the subtree rooted at t,
+// a node timed out doesn't block its parent from closing.
+// Call it more than once returns the same report.
+func (t *Tree) SignalAndWait() Report {
+	t.once.Do(func() {
+		t.mu.Lock()
+		t.closing = true
+		children := t.children
+		hooks := t.hooks
+		t.mu.Unlock()
+
+		var (
+			wg      sync.WaitGroup
+			reports = make([]Report, len(children))
+		)
+		for i, child := range children {
+			wg.Add(1)
+			go func(i int, child *Tree) {
+				defer wg.Done()
+				reports[i] = child.SignalAndWait()
+			}(i, child)
+		}
+		wg.Wait()
+		for _, r := range reports {
+			t.report.Failures = append(t.report.Failures, r.Failures...)
+		}
+
+		t.report.Failures = append(t.report.Failures, t.closeSelf(hooks)...)
+	})
+
+	return t.report
+}
+
+// SignalHandler returns a handler for signal.SetupHandler that closes t
+// on the first signal and passes the report to onReport if not nil
+func (t *Tree) SignalHandler(onReport func(os.Signal, Report)) func(os.Signal) {
+	var once sync.Once
+	return func(sig os.Signal) {
+		once.Do(func() {
+			go func() {
+				report := t.SignalAndWait()
+				if onReport != nil {
+					onReport(sig, report)
+				}
+			}()
+		})
+	}
+}
+
+// closeSelf signals the goroutines of the node and calls the hooks concurrently,
+// since hooks like server shutdown are usually what make the goroutines return
+func (t *Tree) closeSelf(hooks []func() error) (failures []Failure) {
+	var (
+		mu       sync.Mutex
+		hookErrs []error
+		doneCh   = make(chan struct{})
+	)
+	go func() {
+		defer close(doneCh)
+
+		hooksDoneCh := make(chan struct{})
+		go func() {
+			defer close(hooksDoneCh)
+			for i := len(hooks) - 1; i >= 0; i-- {
+				if err := hooks[i](); err != nil {
+					mu.Lock()
+					hookErrs = append(hookErrs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+		t.strict.SignalAndWait()
+		<-hooksDoneCh
+	}()
+
+	timedout := false
+	if t.timeout <= 0 {
+		<-doneCh
+	} else {
+		timer := time.NewTimer(t.timeout)
+		select {
+		case <-doneCh:
+		case <-timer.C:
+			timedout = true
+		}
+		timer.Stop()
+	}
+
+	mu.Lock()
+	for _, err := range hookErrs {
+		failures = append(failures, Failure{Name: t.Name(), Err: err})
+	}
+	mu.Unlock()
+	if timedout {
+		failures = append(failures, Failure{Name: t.Name(), Err: ErrCloseTimeout, Stacks: allStacks()})
+	}
+	return
+}
+
+func allStacks() string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+}
@@ -0,0 +1,105 @@
+package closer
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/zhiqiangxu/util/signal"
+)
+
+func TestTree(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		closed []string
+	)
+	record := func(name string) {
+		mu.Lock()
+		closed = append(closed, name)
+		mu.Unlock()
+	}
+
+	root := NewTree("root", time.Second)
+	server := root.NewChild("server", time.Second)
+	queue := server.NewChild("queue", time.Millisecond*100)
+	pool := server.NewChild("pool", time.Second)
+
+	// a goroutine stops on signal
+	pool.Add(1)
+	go func() {
+		defer pool.Done()
+		<-pool.ClosedSignal()
+		record("pool")
+	}()
+
+	// a goroutine never stops, until root is closing
+	rootCh := make(chan struct{})
+	queueCh := make(chan struct{})
+	queue.Add(1)
+	go func() {
+		defer queue.Done()
+		<-rootCh
+		record("queue")
+		close(queueCh)
+	}()
+
+	errHook := errors.New("hook failed")
+	server.OnClose(func() error {
+		record("server")
+		return errHook
+	})
+	root.OnClose(func() error {
+		record("root")
+		close(rootCh)
+		return nil
+	})
+
+	report := root.SignalAndWait()
+	if report.OK() || len(report.Failures) != 2 {
+		t.Fatal(report)
+	}
+	if f := report.Failures[0]; f.Name != "root/server/queue" || f.Err != ErrCloseTimeout || !strings.Contains(f.Stacks, "TestTree") {
+		t.Fatal(f)
+	}
+	if f := report.Failures[1]; f.Name != "root/server" || f.Err != errHook {
+		t.Fatal(f)
+	}
+
+	// children before parent
+	<-queueCh
+	mu.Lock()
+	if strings.Join(closed, ",") != "pool,server,root,queue" {
+		t.Fatal(closed)
+	}
+	mu.Unlock()
+
+	// closed already
+	if root.Add(1) == nil || pool.Add(1) == nil || root.NewChild("late", 0).Add(1) == nil {
+		t.FailNow()
+	}
+	if len(root.SignalAndWait().Failures) != 2 {
+		t.FailNow()
+	}
+}
+
+func TestTreeSignal(t *testing.T) {
+	root := NewTree("root", time.Second)
+	reportCh := make(chan Report, 1)
+	signal.SetupHandler(root.SignalHandler(func(sig os.Signal, report Report) {
+		reportCh <- report
+	}), syscall.SIGUSR1)
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	select {
+	case report := <-reportCh:
+		if !report.OK() {
+			t.Fatal(report)
+		}
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+}
@@ -0,0 +1,145 @@
+package deadlock
+
+import (
+	"sync"
+
+	"github.com/petermattis/goid"
+)
+
+// maxChanParties bounds the goroutines remembered per direction,
+// beyond which the counterparts are considered unknown.
+const maxChanParties = 64
+
+// Chan is a channel whose blocking Send/Recv are part of the wait-for graph,
+// so that a cycle like "goroutine A holds a Mutex while sending, goroutine B,
+// the only receiver, waits for the Mutex" is detected.
+// A blocked Send waits for the goroutines that have received from it,
+// a blocked Recv waits for the goroutines that have sent to it,
+// and it's a deadlock only if all of them are stuck on the waiting goroutine.
+// Goroutines that haven't used the channel yet are not known as counterparts.
+type Chan[T any] struct {
+	ch        chan T
+	mu        sync.Mutex
+	senders   map[int64]bool
+	receivers map[int64]bool
+	overflow  bool // some counterpart is not remembered
+	site      uintptr
+	id        uint64
+}
+
+// NewChan is ctor for Chan, size is the buffer size
+func NewChan[T any](size int) *Chan[T] {
+	return &Chan[T]{
+		ch:        make(chan T, size),
+		senders:   make(map[int64]bool),
+		receivers: make(map[int64]bool),
+		site:      callerPC(1),
+		id:        newResourceID(),
+	}
+}
+
+// Send blocks until v is sent
+func (c *Chan[T]) Send(v T) {
+	if !Enabled() {
+		c.ch <- v
+		return
+	}
+
+	gid := goid.Get()
+	c.attach(gid, true)
+	select {
+	case c.ch <- v:
+		return
+	default:
+	}
+
+	c.wait(gid, true)
+	defer d.onWaitDoneLocked(gid, c.resourceID())
+	c.ch <- v
+}
+
+// Recv blocks until a value is received, ok is false if closed and drained
+func (c *Chan[T]) Recv() (v T, ok bool) {
+	if !Enabled() {
+		v, ok = <-c.ch
+		return
+	}
+
+	gid := goid.Get()
+	c.attach(gid, false)
+	select {
+	case v, ok = <-c.ch:
+		return
+	default:
+	}
+
+	c.wait(gid, false)
+	defer d.onWaitDoneLocked(gid, c.resourceID())
+	v, ok = <-c.ch
+	return
+}
+
+// Close the channel, pending and future Recv return ok false once drained
+func (c *Chan[T]) Close() {
+	close(c.ch)
+}
+
+// Len returns the number of buffered values
+func (c *Chan[T]) Len() int {
+	return len(c.ch)
+}
+
+// Cap returns the buffer size
+func (c *Chan[T]) Cap() int {
+	return cap(c.ch)
+}
+
+func (c *Chan[T]) resourceID() uint64 {
+	return c.id
+}
+
+func (c *Chan[T]) attach(gid int64, send bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	parties := c.receivers
+	if send {
+		parties = c.senders
+	}
+	if parties[gid] {
+		return
+	}
+	if len(parties) >= maxChanParties {
+		c.overflow = true
+		return
+	}
+	parties[gid] = true
+}
+
+// counterparts is called with detector locked
+func (c *Chan[T]) counterparts(send bool) (gids []int64, known bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	parties := c.senders
+	if send {
+		parties = c.receivers
+	}
+	if c.overflow || len(parties) == 0 {
+		return
+	}
+	for gid := range parties {
+		gids = append(gids, gid)
+	}
+	known = true
+	return
+}
+
+func (c *Chan[T]) wait(gid int64, send bool) {
+	_, err := d.onChanWait(gid, c.resourceID(), send, func() ([]int64, bool) {
+		return c.counterparts(send)
+	}, c.site)
+	if err != nil {
+		report(err)
+	}
+}
@@ -1,10 +1,11 @@
 package deadlock
 
 import (
+	"fmt"
 	"runtime"
 	"sync"
+	"sync/atomic"
 
-	"github.com/petermattis/goid"
 	"github.com/zhiqiangxu/util"
 )
 
@@ -13,6 +14,8 @@ type detector struct {
 	ownerResouces map[int64]map[uint64]bool
 	resouceOwners map[uint64]*resourceOwner
 	waitForMap    map[int64]*waitForResource
+	sites         map[uint64]uintptr // where each tracked resource is created
+	order         *lockOrder         // nil if lock order analysis is off
 }
 
 type resourceOwner struct {
@@ -22,7 +25,10 @@ type resourceOwner struct {
 
 type waitForResource struct {
 	resourceID uint64
-	w          bool
+	w          bool                   // send for channel
+	stack      string                 // only for lock order analysis
+	others     func() ([]int64, bool) // only for channel, the counterparts and whether they are known
+	site       uintptr                // only for channel, which is never owned
 }
 
 func newDetector() *detector {
@@ -30,15 +36,32 @@ func newDetector() *detector {
 		ownerResouces: make(map[int64]map[uint64]bool),
 		resouceOwners: make(map[uint64]*resourceOwner),
 		waitForMap:    make(map[int64]*waitForResource),
+		sites:         make(map[uint64]uintptr),
 	}
 }
 
-func (d *detector) onAcquiredLocked(resourceID uint64, w bool) {
-	gid := goid.Get()
-
+// returns whether gid was waiting for resourceID
+func (d *detector) onAcquiredLocked(gid int64, resourceID uint64, w bool, site uintptr) (waited bool, err error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	d.sites[resourceID] = site
+
+	waitFor := d.waitForMap[gid]
+	waited = waitFor != nil && waitFor.resourceID == resourceID
+
+	if d.order != nil {
+		var stack string
+		if waited {
+			// acquired on behalf of the waiter, current stack is not the waiter's
+			stack = waitFor.stack
+		} else {
+			stack = getCallStack()
+			d.order.onAcquiringLocked(gid, d.ownerResouces[gid], resourceID, stack)
+		}
+		d.order.onAcquiredLocked(gid, resourceID, stack)
+	}
+
 	// update ownerResouces
 	ownedResources := d.ownerResouces[gid]
 	if ownedResources == nil {
@@ -55,7 +78,7 @@ func (d *detector) onAcquiredLocked(resourceID uint64, w bool) {
 	}
 	if w {
 		if resourceOwners.wgid != 0 {
-			panic("write lock holding by more than one owners")
+			err = newErrorUsage("write lock holding by more than one owners")
 		}
 		resourceOwners.wgid = gid
 	} else {
@@ -68,14 +91,43 @@ func (d *detector) onAcquiredLocked(resourceID uint64, w bool) {
 	}
 
 	// update waitForMap
-	delete(d.waitForMap, gid)
+	if waited {
+		delete(d.waitForMap, gid)
+	}
+	return
+}
+
+// onWaitDoneLocked is for a wait that ends without going through onAcquiredLocked
+// returns whether gid was waiting for resourceID
+func (d *detector) onWaitDoneLocked(gid int64, resourceID uint64) (waited bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	waitFor := d.waitForMap[gid]
+	waited = waitFor != nil && waitFor.resourceID == resourceID
+	if waited {
+		delete(d.waitForMap, gid)
+	}
+	if d.resouceOwners[resourceID] == nil {
+		// never acquired
+		delete(d.sites, resourceID)
+	}
+	return
 }
 
 // ErrorDeadlock contains deadlock info
+// Stack is where SourceParty detected the deadlock,
+// OwnerStack is the stack of OwnerParty when the deadlock is reported
 type ErrorDeadlock struct {
 	SourceParty Party
 	OwnerParty  Party
 	Stack       string
+	OwnerStack  string
+}
+
+func (e *ErrorDeadlock) Error() string {
+	return fmt.Sprintf("deadlock: goroutine %d waits for %#x blocked by goroutine %d, which transitively waits for %#x blocked by goroutine %d",
+		e.SourceParty.GID, e.OwnerParty.ResourceID, e.OwnerParty.GID, e.SourceParty.ResourceID, e.SourceParty.GID)
 }
 
 // ErrorUsage for incorrect lock usage
@@ -84,6 +136,14 @@ type ErrorUsage struct {
 	Stack string
 }
 
+func newErrorUsage(msg string) *ErrorUsage {
+	return &ErrorUsage{Msg: msg}
+}
+
+func (e *ErrorUsage) Error() string {
+	return e.Msg
+}
+
 // Party for one side of deadlock
 type Party struct {
 	GID        int64
@@ -122,141 +182,246 @@ func ParsePanicError(panicErr interface{}) (edl *ErrorDeadlock, errUsage *ErrorU
 	panic("bug happened")
 }
 
-func (d *detector) onWaitLocked(resourceID uint64, w bool) {
-	gid := goid.Get()
-
+// waiting is false if the wait is not registered due to usage error
+func (d *detector) onWaitLocked(gid int64, resourceID uint64, w bool, site uintptr) (waiting bool, err error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	if d.waitForMap[gid] != nil {
-		panic("waiting for multiple resources")
+		err = newErrorUsage("waiting for multiple resources")
+		return
 	}
 
-	resourceOwners := d.resouceOwners[resourceID]
-	if resourceOwners == nil {
-		panic("waiting for a resource with no owner")
-	}
-	if resourceOwners.wgid == 0 && len(resourceOwners.rgids) == 0 {
-		panic("waiting for a resource with no owner")
-	}
+	waitFor := &waitForResource{resourceID: resourceID, w: w}
+	d.waitForMap[gid] = waitFor
+	d.sites[resourceID] = site
+	waiting = true
 
-	// detect deadlock
-	var err *ErrorDeadlock
-	// check deadlock with write lock owner
-	if resourceOwners.wgid != 0 {
-		err = d.doDetect(gid, resourceOwners.wgid)
-		if err != nil {
-			err.OwnerParty = Party{GID: resourceOwners.wgid, ResourceID: resourceID, W: true}
-			panic(err)
-		}
-	}
-	// check deadlock with read lock owner
-	for rgid := range resourceOwners.rgids {
-		err = d.doDetect(gid, rgid)
-		if err != nil {
-			err.OwnerParty = Party{GID: rgid, ResourceID: resourceID, W: false}
-			panic(err)
-		}
+	if d.order != nil {
+		waitFor.stack = getCallStack()
+		d.order.onAcquiringLocked(gid, d.ownerResouces[gid], resourceID, waitFor.stack)
 	}
 
-	d.waitForMap[gid] = &waitForResource{resourceID: resourceID, w: w}
+	err = d.detect(gid)
+	return
 }
 
-func (d *detector) doDetect(sourceGID, ownerGID int64) (err *ErrorDeadlock) {
-	waitingForResource := d.waitForMap[ownerGID]
-	if waitingForResource == nil {
+// onChanWait is like onWaitLocked, but gid waits for any of the goroutines returned by others
+func (d *detector) onChanWait(gid int64, resourceID uint64, send bool, others func() ([]int64, bool), site uintptr) (waiting bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.waitForMap[gid] != nil {
+		err = newErrorUsage("waiting for multiple resources")
 		return
 	}
 
-	resourceOwners := d.resouceOwners[waitingForResource.resourceID]
-	if resourceOwners == nil || (resourceOwners.wgid == 0 && len(resourceOwners.rgids) == 0) {
-		panic("waiting for a resource with no owner")
-	}
+	d.waitForMap[gid] = &waitForResource{resourceID: resourceID, w: send, others: others, site: site}
+	waiting = true
 
-	if resourceOwners.wgid != 0 {
-		if resourceOwners.wgid == sourceGID {
-			err = &ErrorDeadlock{SourceParty: Party{
-				GID:        sourceGID,
-				ResourceID: waitingForResource.resourceID,
-				W:          true,
-			}}
+	err = d.detect(gid)
+	return
+}
+
+// blockers returns the goroutines that gid waits for,
+// all means gid is stuck only if all of them are stuck, otherwise any of them.
+func (d *detector) blockers(gid int64, waitFor *waitForResource) (parties []Party, all bool) {
+	if waitFor.others == nil {
+		// the owner is not tracked if it acquired while detection is disabled
+		resourceOwners := d.resouceOwners[waitFor.resourceID]
+		if resourceOwners == nil {
 			return
 		}
-		err = d.doDetect(sourceGID, resourceOwners.wgid)
-		if err != nil {
+		if resourceOwners.wgid != 0 {
+			parties = append(parties, Party{GID: resourceOwners.wgid, ResourceID: waitFor.resourceID, W: true})
+		}
+		for rgid := range resourceOwners.rgids {
+			parties = append(parties, Party{GID: rgid, ResourceID: waitFor.resourceID})
+		}
+		return
+	}
+
+	others, known := waitFor.others()
+	if !known {
+		return
+	}
+	for _, other := range others {
+		if other == gid {
+			continue
+		}
+		if otherWaitFor := d.waitForMap[other]; otherWaitFor != nil && otherWaitFor.resourceID == waitFor.resourceID && otherWaitFor.w != waitFor.w {
+			// the counterpart is about to rendezvous
+			parties = nil
 			return
 		}
+		parties = append(parties, Party{GID: other, ResourceID: waitFor.resourceID})
 	}
+	all = true
+	return
+}
 
-	for rgid := range resourceOwners.rgids {
-		if rgid == sourceGID {
-			err = &ErrorDeadlock{
-				SourceParty: Party{
-					GID:        sourceGID,
-					ResourceID: waitingForResource.resourceID,
-					W:          false,
-				}}
+func (d *detector) detect(gid int64) (err error) {
+	waitFor := d.waitForMap[gid]
+	parties, all := d.blockers(gid, waitFor)
+	if len(parties) == 0 {
+		return
+	}
+
+	var first *ErrorDeadlock
+	for _, p := range parties {
+		if p.GID == gid {
+			err = newErrorUsage("waiting for a resource held by itself")
 			return
 		}
-		err = d.doDetect(sourceGID, rgid)
-		if err != nil {
+
+		edl := d.stuckOn(gid, p.GID, make(map[int64]bool))
+		if edl != nil {
+			edl.OwnerParty = p
+		}
+		if !all && edl != nil {
+			err = edl
 			return
 		}
+		if all {
+			if edl == nil {
+				return
+			}
+			if first == nil {
+				first = edl
+			}
+		}
+	}
+	if first != nil {
+		err = first
 	}
 	return
 }
 
-func (d *detector) onReleaseLocked(resourceID uint64, w bool) {
-	gid := goid.Get()
-
-	d.mu.Lock()
-	defer d.mu.Unlock()
+// stuckOn returns non nil if gid can't make progress unless sourceGID does
+func (d *detector) stuckOn(sourceGID, gid int64, onPath map[int64]bool) (edl *ErrorDeadlock) {
+	if onPath[gid] {
+		// a cycle without sourceGID, leave it to its own detection
+		return
+	}
 
-	// update ownerResouces
-	ownedResources := d.ownerResouces[gid]
-	if ownedResources == nil {
-		panic("releasing a lock not owned")
+	waitFor := d.waitForMap[gid]
+	if waitFor == nil {
+		return
 	}
-	if _, exists := ownedResources[resourceID]; !exists {
-		panic("releasing a lock not owned")
+
+	onPath[gid] = true
+	defer delete(onPath, gid)
+
+	parties, all := d.blockers(gid, waitFor)
+	if len(parties) == 0 {
+		return
 	}
-	delete(ownedResources, resourceID)
-	if len(ownedResources) == 0 {
-		delete(d.ownerResouces, gid)
+
+	var first *ErrorDeadlock
+	for _, p := range parties {
+		var pedl *ErrorDeadlock
+		if p.GID == sourceGID {
+			pedl = &ErrorDeadlock{SourceParty: Party{GID: sourceGID, ResourceID: waitFor.resourceID, W: p.W}}
+		} else {
+			pedl = d.stuckOn(sourceGID, p.GID, onPath)
+		}
+
+		if !all && pedl != nil {
+			edl = pedl
+			return
+		}
+		if all {
+			if pedl == nil {
+				return
+			}
+			if first == nil {
+				first = pedl
+			}
+		}
 	}
 
-	// update resouceOwners
+	edl = first
+	return
+}
+
+// returns false without any change if gid doesn't own resourceID
+func (d *detector) onReleaseLocked(gid int64, resourceID uint64, w bool) (owned bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	resourceOwners := d.resouceOwners[resourceID]
 	if resourceOwners == nil {
-		panic("releasing a lock not owned")
+		return
 	}
 	if w {
 		if resourceOwners.wgid != gid {
-			panic("releasing a lock not owned")
+			return
 		}
 		resourceOwners.wgid = 0
-		if len(resourceOwners.rgids) == 0 {
-			delete(d.resouceOwners, resourceID)
-		}
 	} else {
-		if _, exists := resourceOwners.rgids[gid]; !exists {
-			panic("releasing a lock not owned")
+		if resourceOwners.rgids[gid] <= 0 {
+			return
 		}
-
 		resourceOwners.rgids[gid]--
-		if resourceOwners.rgids[gid] == 0 {
-			delete(resourceOwners.rgids, gid)
-			if len(resourceOwners.rgids) == 0 && resourceOwners.wgid == 0 {
-				delete(d.resouceOwners, resourceID)
+		if resourceOwners.rgids[gid] > 0 {
+			// still holding other read locks
+			owned = true
+			return
+		}
+		delete(resourceOwners.rgids, gid)
+	}
+	owned = true
+
+	if len(resourceOwners.rgids) == 0 && resourceOwners.wgid == 0 {
+		delete(d.resouceOwners, resourceID)
+		delete(d.sites, resourceID)
+	}
+
+	// update ownerResouces
+	ownedResources := d.ownerResouces[gid]
+	delete(ownedResources, resourceID)
+	if len(ownedResources) == 0 {
+		delete(d.ownerResouces, gid)
+	}
+	if d.order != nil {
+		d.order.onReleasedLocked(gid, resourceID)
+	}
+
+	return
+}
+
+// releaseAnyOwner releases resourceID on behalf of one of its owners
+func (d *detector) releaseAnyOwner(resourceID uint64, w bool) (owned bool) {
+	d.mu.Lock()
+	var gid int64
+	if owners := d.resouceOwners[resourceID]; owners != nil {
+		if w {
+			gid = owners.wgid
+		} else {
+			for rgid := range owners.rgids {
+				gid = rgid
+				break
 			}
-		} else if resourceOwners.rgids[gid] < 0 {
-			panic("releasing a read lock too many times")
 		}
 	}
+	d.mu.Unlock()
+
+	if gid == 0 {
+		return
+	}
+	owned = d.onReleaseLocked(gid, resourceID, w)
+	return
 }
 
 var d *detector
 
+var lastResourceID uint64
+
+// newResourceID returns an id never reused, unlike the address of a lock, which may be reused after GC
+func newResourceID() uint64 {
+	return atomic.AddUint64(&lastResourceID, 1)
+}
+
 func init() {
 	d = newDetector()
 }
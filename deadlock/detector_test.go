@@ -1,6 +1,10 @@
 package deadlock
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -12,10 +16,11 @@ func TestDetector(t *testing.T) {
 	lock1.Init()
 	lock2.Init()
 
-	var (
+	type panicErrs struct {
 		errDL    *ErrorDeadlock
 		errUsage *ErrorUsage
-	)
+	}
+	errCh := make(chan panicErrs, 1)
 
 	go func() {
 		lock1.Lock()
@@ -24,7 +29,8 @@ func TestDetector(t *testing.T) {
 
 		defer func() {
 			panicErr := recover()
-			errDL, errUsage = ParsePanicError(panicErr)
+			errDL, errUsage := ParsePanicError(panicErr)
+			errCh <- panicErrs{errDL: errDL, errUsage: errUsage}
 		}()
 
 		lock2.Lock()
@@ -40,14 +46,26 @@ func TestDetector(t *testing.T) {
 		lock1.Lock()
 	}()
 
-	time.Sleep(time.Millisecond * 300)
-	if errDL == nil {
+	var errs panicErrs
+	select {
+	case errs = <-errCh:
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+	if errs.errDL == nil {
 		t.FailNow()
 	}
-	if errUsage != nil {
+	if errs.errUsage != nil {
 		t.FailNow()
 	}
 
+	// panicked without the lock of semaphore held, and the wait is given up
+	lock2.sema.mu.Lock()
+	waiters := lock2.sema.waiters.Len()
+	lock2.sema.mu.Unlock()
+	if waiters != 0 {
+		t.Fatal(waiters)
+	}
 }
 
 func TestMap(t *testing.T) {
@@ -71,3 +89,424 @@ func TestMap(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestContention(t *testing.T) {
+	m := NewMutex()
+	m.Lock()
+
+	doneCh := make(chan struct{})
+	go func() {
+		// acquired on behalf of this goroutine by Unlock below
+		m.Lock()
+		m.Unlock()
+		close(doneCh)
+	}()
+
+	time.Sleep(time.Millisecond * 100)
+	m.Unlock()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+}
+
+func TestLockOrder(t *testing.T) {
+	EnableLockOrder(nil)
+	defer DisableLockOrder()
+
+	a, b, c := NewMutex(), NewMutex(), NewMutex()
+	lockInOrder := func(ms ...*Mutex) {
+		doneCh := make(chan struct{})
+		go func() {
+			for _, m := range ms {
+				m.Lock()
+			}
+			for i := len(ms) - 1; i >= 0; i-- {
+				ms[i].Unlock()
+			}
+			close(doneCh)
+		}()
+		<-doneCh
+	}
+
+	lockInOrder(a, b)
+	lockInOrder(b, c)
+	if len(LockOrderViolations()) != 0 {
+		t.FailNow()
+	}
+
+	// never deadlocks since the goroutines run one after another
+	lockInOrder(c, a)
+	errs := LockOrderViolations()
+	if len(errs) != 1 || len(errs[0].Cycle) != 3 {
+		t.FailNow()
+	}
+	for i, o := range errs[0].Cycle {
+		if o.To != errs[0].Cycle[(i+1)%3].From || o.HeldStack == "" || o.AcquireStack == "" {
+			t.FailNow()
+		}
+	}
+
+	lockInOrder(b, a)
+	if len(LockOrderViolations()) != 2 {
+		t.FailNow()
+	}
+}
+
+func TestLockOrderLimit(t *testing.T) {
+	EnableLockOrder(nil)
+	defer DisableLockOrder()
+	limit := maxLockOrders
+	maxLockOrders = 2
+	defer func() { maxLockOrders = limit }()
+
+	a, b, c, x := NewMutex(), NewMutex(), NewMutex(), NewMutex()
+	for _, pair := range [][2]*Mutex{{a, b}, {b, c}, {x, a}} {
+		pair[0].Lock()
+		pair[1].Lock()
+		pair[1].Unlock()
+		pair[0].Unlock()
+	}
+
+	// a -> b is forgotten, so c -> a closes no cycle
+	c.Lock()
+	a.Lock()
+	a.Unlock()
+	c.Unlock()
+	if len(LockOrderViolations()) != 0 {
+		t.FailNow()
+	}
+
+	d.mu.Lock()
+	n := len(d.order.fifo)
+	d.mu.Unlock()
+	if n != 2 {
+		t.Fatal(n)
+	}
+}
+
+func TestReporter(t *testing.T) {
+	errCh := make(chan error, 10)
+	SetReporter(ReporterFunc(func(err error) {
+		errCh <- err
+	}))
+	defer SetReporter(nil)
+
+	// usage error is reported instead of panic
+	m := NewMutex()
+	m.Lock()
+	doneCh := make(chan struct{})
+	go func() {
+		m.Unlock()
+		close(doneCh)
+	}()
+	<-doneCh
+	select {
+	case err := <-errCh:
+		errUsage, ok := err.(*ErrorUsage)
+		if !ok || errUsage.Stack == "" {
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+	// the owner is forgotten
+	d.mu.Lock()
+	owners := d.resouceOwners[m.resourceID()]
+	d.mu.Unlock()
+	if owners != nil || !m.TryLock() {
+		t.FailNow()
+	}
+	m.Unlock()
+
+	// deadlock is reported instead of panic, the goroutines stay blocked
+	lock1, lock2 := NewMutex(), NewMutex()
+	go func() {
+		lock1.Lock()
+		time.Sleep(time.Millisecond * 200)
+		lock2.Lock()
+	}()
+	time.Sleep(time.Millisecond * 100)
+	go func() {
+		lock2.Lock()
+		lock1.Lock()
+	}()
+	select {
+	case err := <-errCh:
+		errDL, ok := err.(*ErrorDeadlock)
+		if !ok || errDL.Stack == "" {
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+}
+
+func TestDisable(t *testing.T) {
+	errCh := make(chan error, 10)
+	SetReporter(ReporterFunc(func(err error) {
+		errCh <- err
+	}))
+	defer SetReporter(nil)
+
+	rw := NewRWMutex()
+
+	// acquired while disabled, released while enabled
+	Disable()
+	rw.RLock()
+	rw.RLock()
+	Enable()
+	rw.RLock()
+	rw.RUnlock()
+	rw.RUnlock()
+	rw.RUnlock()
+
+	// acquired while enabled, released while disabled
+	rw.Lock()
+	Disable()
+	rw.Unlock()
+	Enable()
+
+	if len(errCh) != 0 {
+		t.Fatal(<-errCh)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.resouceOwners[rw.resourceID()] != nil {
+		t.FailNow()
+	}
+}
+
+func BenchmarkMutex(b *testing.B) {
+	m := NewMutex()
+	for i := 0; i < b.N; i++ {
+		m.Lock()
+		m.Unlock()
+	}
+}
+
+func BenchmarkMutexDisabled(b *testing.B) {
+	Disable()
+	defer Enable()
+
+	m := NewMutex()
+	for i := 0; i < b.N; i++ {
+		m.Lock()
+		m.Unlock()
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	errCh := make(chan error, 10)
+	SetReporter(ReporterFunc(func(err error) {
+		errCh <- err
+	}))
+	defer SetReporter(nil)
+	SetReportHeldTooLong(true)
+	defer SetReportHeldTooLong(false)
+
+	m1, m2 := NewMutex(), NewRWMutex()
+	m1.Lock()
+
+	holdingCh := make(chan struct{})
+	releaseCh := make(chan struct{})
+	go func() {
+		m2.RLock()
+		close(holdingCh)
+		// times out on m1 held by the test goroutine, the wait is canceled
+		if m1.LockTimeout(time.Millisecond*100) != context.DeadlineExceeded {
+			t.Error("LockTimeout should time out")
+		}
+		<-releaseCh
+		m2.RUnlock()
+	}()
+	<-holdingCh
+
+	select {
+	case err := <-errCh:
+		errHeld, ok := err.(*ErrorHeldTooLong)
+		if !ok || len(errHeld.Holders) != 1 || !strings.Contains(errHeld.Holders[0].Stack, "TestLockTimeout") {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+
+	// not a deadlock since the wait for m1 has been canceled
+	time.AfterFunc(time.Millisecond*100, func() {
+		close(releaseCh)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if m2.LockContext(ctx) != nil {
+		t.FailNow()
+	}
+	m2.Unlock()
+	m1.Unlock()
+
+	if len(errCh) != 0 {
+		t.Fatal(<-errCh)
+	}
+
+	// a lock waited for but never acquired is forgotten after the wait
+	m3 := NewMutex()
+	Disable()
+	m3.Lock()
+	Enable()
+	if m3.LockTimeout(time.Millisecond*10) != context.DeadlineExceeded {
+		t.FailNow()
+	}
+	d.mu.Lock()
+	_, ok := d.sites[m3.resourceID()]
+	d.mu.Unlock()
+	if ok {
+		t.Fatal("site of m3 not forgotten")
+	}
+	Disable()
+	m3.Unlock()
+	Enable()
+}
+
+func TestProfile(t *testing.T) {
+	EnableProfile()
+	defer DisableProfile()
+
+	m := NewMutex()
+	m.Lock()
+	doneCh := make(chan struct{})
+	go func() {
+		m.Lock()
+		m.Unlock()
+		close(doneCh)
+	}()
+	time.Sleep(time.Millisecond * 100)
+
+	// m is held by the test goroutine and waited by the other
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/deadlock", nil))
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, "TestProfile") || !strings.Contains(body, "waited by goroutine") {
+		t.Fatal(body)
+	}
+
+	m.Unlock()
+	<-doneCh
+
+	var found bool
+	for _, p := range Profile() {
+		if !strings.Contains(p.Site, "TestProfile") {
+			continue
+		}
+		found = true
+		if p.Acquisitions != 2 || p.Contentions != 1 || p.Holding != 0 || p.WaitTotal < time.Millisecond*100 || p.HoldTotal < p.WaitTotal {
+			t.Fatal(p)
+		}
+	}
+	if !found {
+		t.FailNow()
+	}
+}
+
+func TestChan(t *testing.T) {
+	errCh := make(chan error, 10)
+	SetReporter(ReporterFunc(func(err error) {
+		errCh <- err
+	}))
+	defer SetReporter(nil)
+
+	// values pass through as a plain channel
+	c := NewChan[int](1)
+	c.Send(1)
+	if v, ok := c.Recv(); !ok || v != 1 || c.Len() != 0 || c.Cap() != 1 {
+		t.FailNow()
+	}
+
+	// not a deadlock: the receiver is running
+	m := NewMutex()
+	ch := NewChan[int](0)
+	go func() {
+		for {
+			if _, ok := ch.Recv(); !ok {
+				return
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		ch.Send(i)
+	}
+	ch.Close()
+	if len(errCh) != 0 {
+		t.Fatal(<-errCh)
+	}
+
+	// holds m while sending, the only receiver waits for m
+	c = NewChan[int](0)
+	go func() {
+		c.Recv()
+		m.Lock()
+		c.Recv()
+	}()
+	go func() {
+		c.Send(0)
+		m.Lock()
+		c.Send(1)
+	}()
+
+	select {
+	case err := <-errCh:
+		errDL, ok := err.(*ErrorDeadlock)
+		if !ok || errDL.Stack == "" || errDL.OwnerStack == "" {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+}
+
+func TestWaitGroup(t *testing.T) {
+	errCh := make(chan error, 10)
+	SetReporter(ReporterFunc(func(err error) {
+		errCh <- err
+	}))
+	defer SetReporter(nil)
+
+	// not a deadlock: the goroutines are running
+	var wg WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Go(func() {
+			time.Sleep(time.Millisecond * 10)
+		})
+	}
+	wg.Wait()
+	if len(errCh) != 0 {
+		t.Fatal(<-errCh)
+	}
+
+	// waits while holding m, which the goroutine needs to be done
+	m := NewMutex()
+	startedCh := make(chan struct{})
+	go func() {
+		m.Lock()
+		wg := NewWaitGroup()
+		wg.Go(func() {
+			close(startedCh)
+			m.Lock()
+		})
+		<-startedCh
+		time.Sleep(time.Millisecond * 100)
+		wg.Wait()
+	}()
+
+	select {
+	case err := <-errCh:
+		errDL, ok := err.(*ErrorDeadlock)
+		if !ok || !strings.Contains(errDL.OwnerStack, "TestWaitGroup") {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+}
@@ -0,0 +1,121 @@
+package deadlock
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/petermattis/goid"
+	"github.com/zhiqiangxu/util"
+)
+
+var reportHeldTooLong uint32
+
+// SetReportHeldTooLong decides whether to report *ErrorHeldTooLong when
+// LockContext/RLockContext/LockTimeout/RLockTimeout times out, default false
+func SetReportHeldTooLong(on bool) {
+	var v uint32
+	if on {
+		v = 1
+	}
+	atomic.StoreUint32(&reportHeldTooLong, v)
+}
+
+// ErrorHeldTooLong is reported when a timed acquisition times out,
+// which means the lock is held for too long by Holders
+type ErrorHeldTooLong struct {
+	Waiter  Party
+	Holders []Holder
+	Stack   string
+}
+
+// Holder of a lock
+type Holder struct {
+	GID   int64
+	W     bool
+	Stack string // stack of the holder when the waiter timed out
+}
+
+func (e *ErrorHeldTooLong) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "lock held too long: goroutine %d timed out waiting for %#x\n", e.Waiter.GID, e.Waiter.ResourceID)
+	for _, h := range e.Holders {
+		fmt.Fprintf(&b, "held by %s\n", h.Stack)
+	}
+	return b.String()
+}
+
+// onHeldTooLong is called after a timed out wait is canceled, so it can panic safely
+func onHeldTooLong(resourceID uint64, w bool) {
+	if atomic.LoadUint32(&reportHeldTooLong) == 0 || !Enabled() {
+		return
+	}
+
+	holders := d.holders(resourceID)
+	if len(holders) == 0 {
+		// released just now
+		return
+	}
+
+	gids := make(map[int64]bool, len(holders))
+	for _, h := range holders {
+		gids[h.GID] = true
+	}
+	stacks := getGoroutineStacks(gids)
+	for i := range holders {
+		holders[i].Stack = stacks[holders[i].GID]
+	}
+
+	report(&ErrorHeldTooLong{
+		Waiter:  Party{GID: goid.Get(), ResourceID: resourceID, W: w},
+		Holders: holders,
+	})
+}
+
+func (d *detector) holders(resourceID uint64) (holders []Holder) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	resourceOwners := d.resouceOwners[resourceID]
+	if resourceOwners == nil {
+		return
+	}
+	if resourceOwners.wgid != 0 {
+		holders = append(holders, Holder{GID: resourceOwners.wgid, W: true})
+	}
+	for rgid := range resourceOwners.rgids {
+		holders = append(holders, Holder{GID: rgid})
+	}
+	return
+}
+
+// getGoroutineStacks returns stacks of the specified goroutines
+func getGoroutineStacks(gids map[int64]bool) map[int64]string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+
+	stacks := make(map[int64]string, len(gids))
+	// goroutines are separated by blank line, each starts with "goroutine N ["
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		fields := bytes.SplitN(g, []byte(" "), 3)
+		if len(fields) < 3 || !bytes.Equal(fields[0], []byte("goroutine")) {
+			continue
+		}
+		gid, err := strconv.ParseInt(util.String(fields[1]), 10, 64)
+		if err != nil || !gids[gid] {
+			continue
+		}
+		stacks[gid] = string(g)
+	}
+	return stacks
+}
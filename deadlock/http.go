@@ -0,0 +1,110 @@
+package deadlock
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// LockState is the current holders and waiters of a lock tracked by the detector
+type LockState struct {
+	ResourceID uint64
+	Site       string
+	Writer     int64 // 0 if not write locked
+	Readers    []int64
+	Waiters    []Party
+}
+
+// LockStates returns all locks that are held or waited for
+func LockStates() []LockState {
+	return d.lockStates()
+}
+
+func (d *detector) lockStates() (states []LockState) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	idx := make(map[uint64]int)
+	stateOf := func(resourceID uint64) *LockState {
+		i, ok := idx[resourceID]
+		if !ok {
+			i = len(states)
+			idx[resourceID] = i
+			states = append(states, LockState{ResourceID: resourceID, Site: siteString(d.sites[resourceID])})
+		}
+		return &states[i]
+	}
+
+	for resourceID, owners := range d.resouceOwners {
+		state := stateOf(resourceID)
+		state.Writer = owners.wgid
+		for rgid := range owners.rgids {
+			state.Readers = append(state.Readers, rgid)
+		}
+	}
+	for gid, waitFor := range d.waitForMap {
+		state := stateOf(waitFor.resourceID)
+		if _, ok := d.sites[waitFor.resourceID]; !ok && waitFor.site != 0 {
+			state.Site = siteString(waitFor.site)
+		}
+		state.Waiters = append(state.Waiters, Party{GID: gid, ResourceID: waitFor.resourceID, W: waitFor.w})
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		if len(states[i].Waiters) != len(states[j].Waiters) {
+			return len(states[i].Waiters) > len(states[j].Waiters)
+		}
+		return states[i].ResourceID < states[j].ResourceID
+	})
+	return
+}
+
+const defaultReportTop = 20
+
+// Handler serves a plain text report of the most contended locks and
+// the current holders/waiters, query parameter "top" limits the number of locks
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		top := defaultReportTop
+		if v := r.URL.Query().Get("top"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid top", http.StatusBadRequest)
+				return
+			}
+			top = n
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		fmt.Fprintf(w, "top contended locks (profile enabled: %v)\n\n", profiling())
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "contentions\tacquisitions\twait total\twait max\thold total\tholding\tsite")
+		for i, p := range Profile() {
+			if i >= top {
+				break
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%v\t%v\t%v\t%d\t%s\n", p.Contentions, p.Acquisitions, p.WaitTotal, p.MaxWait, p.HoldTotal, p.Holding, p.Site)
+		}
+		tw.Flush()
+
+		fmt.Fprintf(w, "\nholders and waiters (detection enabled: %v)\n", Enabled())
+		for i, state := range LockStates() {
+			if i >= top {
+				break
+			}
+			fmt.Fprintf(w, "\nlock %#x created at %s\n", state.ResourceID, state.Site)
+			if state.Writer != 0 {
+				fmt.Fprintf(w, "  write locked by goroutine %d\n", state.Writer)
+			}
+			for _, gid := range state.Readers {
+				fmt.Fprintf(w, "  read locked by goroutine %d\n", gid)
+			}
+			for _, waiter := range state.Waiters {
+				fmt.Fprintf(w, "  waited by goroutine %d (write: %v)\n", waiter.GID, waiter.W)
+			}
+		}
+	})
+}
@@ -2,32 +2,58 @@ package deadlock
 
 import (
 	"context"
-	"unsafe"
+	"time"
 )
 
 // Mutex is like sync.Mutex but with builtin deadlock detecting ability
 type Mutex struct {
 	sema *Weighted
+	t    tracker
 }
 
 // NewMutex is ctor for Mutex
 func NewMutex() *Mutex {
 	m := &Mutex{}
-	m.Init()
+	m.init(callerPC(1))
 	return m
 }
 
 // Init for embeded usage
 func (m *Mutex) Init() {
+	m.init(callerPC(1))
+}
+
+// site is where the lock is created, for profile
+func (m *Mutex) init(site uintptr) {
 	m.sema = NewWeighted(1, m)
+	m.t.site = site
+	m.t.id = newResourceID()
 }
 
-// Lock with context
+// Lock blocks until the lock is acquired
 func (m *Mutex) Lock() (err error) {
 	err = m.sema.Acquire(context.Background(), 1)
 	return
 }
 
+// LockContext returns ctx.Err() if ctx is done before the lock is acquired
+func (m *Mutex) LockContext(ctx context.Context) (err error) {
+	err = m.sema.Acquire(ctx, 1)
+	if err == context.DeadlineExceeded {
+		onHeldTooLong(m.resourceID(), true)
+	}
+	return
+}
+
+// LockTimeout returns context.DeadlineExceeded if the lock is not acquired within timeout
+func (m *Mutex) LockTimeout(timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err = m.LockContext(ctx)
+	return
+}
+
 // Unlock should only be called after a successful Lock
 func (m *Mutex) Unlock() {
 	m.sema.Release(1)
@@ -38,18 +64,26 @@ func (m *Mutex) TryLock() bool {
 	return m.sema.TryAcquire(1)
 }
 
-func (m *Mutex) onAcquiredLocked(n int64) {
-	d.onAcquiredLocked(uint64(uintptr(unsafe.Pointer(m))), true)
+func (m *Mutex) resourceID() uint64 {
+	return m.t.id
+}
+
+func (m *Mutex) onAcquiredLocked(gid int64, n int64) error {
+	return m.t.onAcquiredLocked(gid, m.resourceID(), true, n)
+}
+
+func (m *Mutex) onWaitLocked(gid int64, n int64) error {
+	return m.t.onWaitLocked(gid, m.resourceID(), true)
 }
 
-func (m *Mutex) onWaitLocked(n int64) {
-	d.onWaitLocked(uint64(uintptr(unsafe.Pointer(m))), true)
+func (m *Mutex) onWaitCanceledLocked(gid int64, n int64) {
+	m.t.onWaitCanceledLocked(gid, m.resourceID())
 }
 
-func (m *Mutex) onWaitCanceledLocked(n int64) {
-	panic("this should never happen")
+func (m *Mutex) onWaited(n int64, waited time.Duration) {
+	m.t.onWaited(waited)
 }
 
-func (m *Mutex) onReleaseLocked(n int64) {
-	d.onReleaseLocked(uint64(uintptr(unsafe.Pointer(m))), true)
+func (m *Mutex) onReleaseLocked(gid int64, n int64) error {
+	return m.t.onReleaseLocked(gid, m.resourceID(), true, n)
 }
@@ -0,0 +1,183 @@
+package deadlock
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lock order analysis records "To acquired while holding From" across goroutines,
+// a cycle in these orders is a potential deadlock even if it never happened.
+// it's meant for tests since a stack is captured for every acquisition.
+// locks are identified by resourceID, which is never reused,
+// and at most maxLockOrders orders are kept, the oldest are forgotten.
+
+var maxLockOrders = 1 << 16
+
+// LockOrder is an observed acquisition order: To is acquired while holding From
+type LockOrder struct {
+	GID          int64
+	From         uint64
+	To           uint64
+	HeldStack    string // where From was acquired
+	AcquireStack string // where To was acquired
+}
+
+// ErrorLockOrder is a potential deadlock caused by inconsistent lock order,
+// Cycle[i].To == Cycle[i+1].From, and the last To is the first From
+type ErrorLockOrder struct {
+	Cycle []LockOrder
+}
+
+func (e *ErrorLockOrder) Error() string {
+	var b strings.Builder
+	b.WriteString("potential deadlock by inconsistent lock order:\n")
+	for _, o := range e.Cycle {
+		fmt.Fprintf(&b, "goroutine %d acquires %#x while holding %#x\n", o.GID, o.To, o.From)
+		fmt.Fprintf(&b, "%#x acquired at:\n%s\n", o.From, o.HeldStack)
+		fmt.Fprintf(&b, "%#x acquired at:\n%s\n", o.To, o.AcquireStack)
+	}
+	return b.String()
+}
+
+type lockOrder struct {
+	orderEdges map[uint64]map[uint64]*LockOrder // from -> to
+	fifo       []orderKey                       // in the order added to orderEdges
+	heldStacks map[int64]map[uint64]string
+	violations []*ErrorLockOrder
+	cb         func(*ErrorLockOrder)
+}
+
+type orderKey struct {
+	from, to uint64
+}
+
+func newLockOrder(cb func(*ErrorLockOrder)) *lockOrder {
+	return &lockOrder{
+		orderEdges: make(map[uint64]map[uint64]*LockOrder),
+		heldStacks: make(map[int64]map[uint64]string),
+		cb:         cb,
+	}
+}
+
+// EnableLockOrder turns on lock order analysis,
+// cb is called for each new potential deadlock if not nil,
+// it's called with internal locks held, so it must not use locks from this package
+func EnableLockOrder(cb func(*ErrorLockOrder)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.order = newLockOrder(cb)
+}
+
+// DisableLockOrder turns off lock order analysis and forgets all recorded orders
+func DisableLockOrder() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.order = nil
+}
+
+// LockOrderViolations returns all potential deadlocks found since EnableLockOrder
+func LockOrderViolations() (errs []*ErrorLockOrder) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.order == nil {
+		return
+	}
+	errs = append(errs, d.order.violations...)
+	return
+}
+
+// onAcquiringLocked is called before gid blocks on or acquires resourceID,
+// stack is where resourceID is being acquired.
+func (o *lockOrder) onAcquiringLocked(gid int64, ownedResources map[uint64]bool, resourceID uint64, stack string) {
+	for from := range ownedResources {
+		if from == resourceID {
+			continue
+		}
+		tos := o.orderEdges[from]
+		if tos == nil {
+			tos = make(map[uint64]*LockOrder)
+			o.orderEdges[from] = tos
+		}
+		if tos[resourceID] != nil {
+			// already known, so are the cycles it closes
+			continue
+		}
+
+		edge := &LockOrder{
+			GID:          gid,
+			From:         from,
+			To:           resourceID,
+			HeldStack:    o.heldStacks[gid][from],
+			AcquireStack: stack,
+		}
+		tos[resourceID] = edge
+		o.fifo = append(o.fifo, orderKey{from: from, to: resourceID})
+		if len(o.fifo) > maxLockOrders {
+			o.forgetOldestLocked()
+		}
+
+		path := o.findPath(resourceID, from, make(map[uint64]bool))
+		if path == nil {
+			continue
+		}
+
+		err := &ErrorLockOrder{Cycle: []LockOrder{*edge}}
+		for _, e := range path {
+			err.Cycle = append(err.Cycle, *e)
+		}
+		o.violations = append(o.violations, err)
+		if o.cb != nil {
+			o.cb(err)
+		}
+	}
+}
+
+func (o *lockOrder) forgetOldestLocked() {
+	k := o.fifo[0]
+	o.fifo = o.fifo[1:]
+
+	tos := o.orderEdges[k.from]
+	delete(tos, k.to)
+	if len(tos) == 0 {
+		delete(o.orderEdges, k.from)
+	}
+}
+
+// findPath returns the orders from -> ... -> to if any
+func (o *lockOrder) findPath(from, to uint64, visited map[uint64]bool) []*LockOrder {
+	visited[from] = true
+	for next, e := range o.orderEdges[from] {
+		if next == to {
+			return []*LockOrder{e}
+		}
+		if visited[next] {
+			continue
+		}
+		if path := o.findPath(next, to, visited); path != nil {
+			return append([]*LockOrder{e}, path...)
+		}
+	}
+	return nil
+}
+
+func (o *lockOrder) onAcquiredLocked(gid int64, resourceID uint64, stack string) {
+	stacks := o.heldStacks[gid]
+	if stacks == nil {
+		stacks = make(map[uint64]string)
+		o.heldStacks[gid] = stacks
+	}
+	if _, exists := stacks[resourceID]; !exists {
+		stacks[resourceID] = stack
+	}
+}
+
+func (o *lockOrder) onReleasedLocked(gid int64, resourceID uint64) {
+	stacks := o.heldStacks[gid]
+	delete(stacks, resourceID)
+	if len(stacks) == 0 {
+		delete(o.heldStacks, gid)
+	}
+}
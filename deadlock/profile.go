@@ -0,0 +1,131 @@
+package deadlock
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// profile accumulates contention per lock creation site,
+// so that all locks created at the same place are counted as one logical lock.
+
+var (
+	profileOn    uint32
+	profileStart = time.Now()
+	profileSites sync.Map // uintptr -> *lockStats
+)
+
+// EnableProfile starts accumulating contention stats
+func EnableProfile() {
+	atomic.StoreUint32(&profileOn, 1)
+}
+
+// DisableProfile stops accumulating contention stats, accumulated ones are kept
+func DisableProfile() {
+	atomic.StoreUint32(&profileOn, 0)
+}
+
+func profiling() bool {
+	return atomic.LoadUint32(&profileOn) != 0
+}
+
+type lockStats struct {
+	acquisitions int64
+	contentions  int64
+	waitNanos    int64
+	maxWaitNanos int64
+	// sum of (release - acquire) for finished holds,
+	// minus acquire for ongoing holds
+	holdNanos int64
+	holding   int64
+}
+
+func statsOf(site uintptr) *lockStats {
+	if st, ok := profileSites.Load(site); ok {
+		return st.(*lockStats)
+	}
+	st, _ := profileSites.LoadOrStore(site, &lockStats{})
+	return st.(*lockStats)
+}
+
+func profileNanos() int64 {
+	return int64(time.Since(profileStart))
+}
+
+func (st *lockStats) onAcquired() {
+	atomic.AddInt64(&st.acquisitions, 1)
+	atomic.AddInt64(&st.holding, 1)
+	atomic.AddInt64(&st.holdNanos, -profileNanos())
+}
+
+func (st *lockStats) onReleased() {
+	atomic.AddInt64(&st.holdNanos, profileNanos())
+	atomic.AddInt64(&st.holding, -1)
+}
+
+func (st *lockStats) onWaited(waited time.Duration) {
+	atomic.AddInt64(&st.contentions, 1)
+	atomic.AddInt64(&st.waitNanos, int64(waited))
+	for {
+		old := atomic.LoadInt64(&st.maxWaitNanos)
+		if int64(waited) <= old || atomic.CompareAndSwapInt64(&st.maxWaitNanos, old, int64(waited)) {
+			return
+		}
+	}
+}
+
+// LockProfile is the contention stats of locks created at Site
+type LockProfile struct {
+	Site         string
+	Acquisitions int64
+	Contentions  int64
+	WaitTotal    time.Duration
+	MaxWait      time.Duration
+	HoldTotal    time.Duration // including ongoing holds
+	Holding      int64
+}
+
+// Profile returns the stats of all lock creation sites, most contended first
+func Profile() (ps []LockProfile) {
+	now := profileNanos()
+	profileSites.Range(func(k, v interface{}) bool {
+		st := v.(*lockStats)
+		holding := atomic.LoadInt64(&st.holding)
+		ps = append(ps, LockProfile{
+			Site:         siteString(k.(uintptr)),
+			Acquisitions: atomic.LoadInt64(&st.acquisitions),
+			Contentions:  atomic.LoadInt64(&st.contentions),
+			WaitTotal:    time.Duration(atomic.LoadInt64(&st.waitNanos)),
+			MaxWait:      time.Duration(atomic.LoadInt64(&st.maxWaitNanos)),
+			HoldTotal:    time.Duration(atomic.LoadInt64(&st.holdNanos) + holding*now),
+			Holding:      holding,
+		})
+		return true
+	})
+
+	sort.Slice(ps, func(i, j int) bool {
+		if ps[i].Contentions != ps[j].Contentions {
+			return ps[i].Contentions > ps[j].Contentions
+		}
+		return ps[i].WaitTotal > ps[j].WaitTotal
+	})
+	return
+}
+
+// callerPC returns the pc of the caller skip frames above the caller of callerPC
+func callerPC(skip int) uintptr {
+	var pcs [1]uintptr
+	runtime.Callers(skip+2, pcs[:])
+	return pcs[0]
+}
+
+func siteString(pc uintptr) string {
+	if pc == 0 {
+		return "unknown"
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	return fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line)
+}
@@ -0,0 +1,136 @@
+package deadlock
+
+import (
+	"sync/atomic"
+
+	kitmetrics "github.com/go-kit/kit/metrics"
+	"github.com/zhiqiangxu/util/logger"
+	"github.com/zhiqiangxu/util/metrics"
+	"go.uber.org/zap"
+)
+
+// Reporter is notified of every problem found by the detector,
+// err is one of *ErrorDeadlock, *ErrorUsage and *ErrorHeldTooLong, with Stack filled.
+// Report is called with internal locks held, so it must not use locks from this package.
+type Reporter interface {
+	Report(err error)
+}
+
+// ReporterFunc adapts a func to Reporter
+type ReporterFunc func(err error)
+
+// Report implements Reporter
+func (f ReporterFunc) Report(err error) {
+	f(err)
+}
+
+// PanicReporter panics, which is the default,
+// the panic can be handled by ParsePanicError
+type PanicReporter struct{}
+
+// Report implements Reporter
+func (PanicReporter) Report(err error) {
+	if errUsage, ok := err.(*ErrorUsage); ok {
+		panic(errUsage.Msg)
+	}
+	panic(err)
+}
+
+// LogReporter logs via logger.Instance()
+type LogReporter struct{}
+
+// Report implements Reporter
+func (LogReporter) Report(err error) {
+	logger.Instance().Error("deadlock detector", zap.String("kind", errKind(err)), zap.Error(err), zap.String("stack", errStack(err)))
+}
+
+// MetricReporter increments a counter with label "kind"
+type MetricReporter struct {
+	counter kitmetrics.Counter
+}
+
+// NewMetricReporter registers a counter by name
+func NewMetricReporter(name string) *MetricReporter {
+	return &MetricReporter{counter: metrics.RegisterCounter(name, []string{"kind"})}
+}
+
+// Report implements Reporter
+func (r *MetricReporter) Report(err error) {
+	r.counter.With("kind", errKind(err)).Add(1)
+}
+
+// MultiReporter reports to each Reporter in turn
+type MultiReporter []Reporter
+
+// Report implements Reporter
+func (rs MultiReporter) Report(err error) {
+	for _, r := range rs {
+		r.Report(err)
+	}
+}
+
+type reporterHolder struct {
+	r Reporter
+}
+
+var reporter atomic.Value
+
+func init() {
+	reporter.Store(reporterHolder{r: PanicReporter{}})
+}
+
+// SetReporter changes how problems are reported, nil means PanicReporter
+func SetReporter(r Reporter) {
+	if r == nil {
+		r = PanicReporter{}
+	}
+	reporter.Store(reporterHolder{r: r})
+}
+
+func report(err error) {
+	switch e := err.(type) {
+	case *ErrorDeadlock:
+		e.Stack = getCallStack()
+		e.OwnerStack = getGoroutineStacks(map[int64]bool{e.OwnerParty.GID: true})[e.OwnerParty.GID]
+	case *ErrorUsage:
+		e.Stack = getCallStack()
+	case *ErrorHeldTooLong:
+		e.Stack = getCallStack()
+	}
+	reporter.Load().(reporterHolder).r.Report(err)
+}
+
+// reportAll reports the errors not nil
+func reportAll(errs ...error) {
+	for _, err := range errs {
+		if err != nil {
+			report(err)
+		}
+	}
+}
+
+func errKind(err error) string {
+	switch err.(type) {
+	case *ErrorDeadlock:
+		return "deadlock"
+	case *ErrorUsage:
+		return "usage"
+	case *ErrorHeldTooLong:
+		return "held_too_long"
+	default:
+		return "unknown"
+	}
+}
+
+func errStack(err error) string {
+	switch e := err.(type) {
+	case *ErrorDeadlock:
+		return e.Stack
+	case *ErrorUsage:
+		return e.Stack
+	case *ErrorHeldTooLong:
+		return e.Stack
+	default:
+		return ""
+	}
+}
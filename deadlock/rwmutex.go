@@ -2,7 +2,7 @@ package deadlock
 
 import (
 	"context"
-	"unsafe"
+	"time"
 )
 
 const rwmutexMaxReaders = 1 << 30
@@ -10,18 +10,26 @@ const rwmutexMaxReaders = 1 << 30
 // RWMutex is like sync.RWMutex but with builtin deadlock detecting ability
 type RWMutex struct {
 	sema *Weighted
+	t    tracker
 }
 
 // NewRWMutex is ctor for RWMutex
 func NewRWMutex() *RWMutex {
 	rw := &RWMutex{}
-	rw.Init()
+	rw.init(callerPC(1))
 	return rw
 }
 
 // Init for embeded usage
 func (rw *RWMutex) Init() {
+	rw.init(callerPC(1))
+}
+
+// site is where the lock is created, for profile
+func (rw *RWMutex) init(site uintptr) {
 	rw.sema = NewWeighted(rwmutexMaxReaders, rw)
+	rw.t.site = site
+	rw.t.id = newResourceID()
 }
 
 // Lock for write lock
@@ -36,11 +44,47 @@ func (rw *RWMutex) Unlock() {
 	return
 }
 
+// LockContext returns ctx.Err() if ctx is done before the write lock is acquired
+func (rw *RWMutex) LockContext(ctx context.Context) (err error) {
+	err = rw.sema.Acquire(ctx, rwmutexMaxReaders)
+	if err == context.DeadlineExceeded {
+		onHeldTooLong(rw.resourceID(), true)
+	}
+	return
+}
+
+// LockTimeout returns context.DeadlineExceeded if the write lock is not acquired within timeout
+func (rw *RWMutex) LockTimeout(timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err = rw.LockContext(ctx)
+	return
+}
+
 // RLock for read lock
 func (rw *RWMutex) RLock() {
 	rw.sema.Acquire(context.Background(), 1)
 }
 
+// RLockContext returns ctx.Err() if ctx is done before the read lock is acquired
+func (rw *RWMutex) RLockContext(ctx context.Context) (err error) {
+	err = rw.sema.Acquire(ctx, 1)
+	if err == context.DeadlineExceeded {
+		onHeldTooLong(rw.resourceID(), false)
+	}
+	return
+}
+
+// RLockTimeout returns context.DeadlineExceeded if the read lock is not acquired within timeout
+func (rw *RWMutex) RLockTimeout(timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err = rw.RLockContext(ctx)
+	return
+}
+
 // RUnlock should only be called after a successful RLock
 func (rw *RWMutex) RUnlock() {
 	rw.sema.Release(1)
@@ -56,18 +100,26 @@ func (rw *RWMutex) TryRLock() bool {
 	return rw.sema.TryAcquire(1)
 }
 
-func (rw *RWMutex) onAcquiredLocked(n int64) {
-	d.onAcquiredLocked(uint64(uintptr(unsafe.Pointer(rw))), n == rwmutexMaxReaders)
+func (rw *RWMutex) resourceID() uint64 {
+	return rw.t.id
+}
+
+func (rw *RWMutex) onAcquiredLocked(gid int64, n int64) error {
+	return rw.t.onAcquiredLocked(gid, rw.resourceID(), n == rwmutexMaxReaders, n)
+}
+
+func (rw *RWMutex) onWaitLocked(gid int64, n int64) error {
+	return rw.t.onWaitLocked(gid, rw.resourceID(), n == rwmutexMaxReaders)
 }
 
-func (rw *RWMutex) onWaitLocked(n int64) {
-	d.onWaitLocked(uint64(uintptr(unsafe.Pointer(rw))), n == rwmutexMaxReaders)
+func (rw *RWMutex) onWaitCanceledLocked(gid int64, n int64) {
+	rw.t.onWaitCanceledLocked(gid, rw.resourceID())
 }
 
-func (rw *RWMutex) onWaitCanceledLocked(n int64) {
-	panic("this should never happen")
+func (rw *RWMutex) onWaited(n int64, waited time.Duration) {
+	rw.t.onWaited(waited)
 }
 
-func (rw *RWMutex) onReleaseLocked(n int64) {
-	d.onReleaseLocked(uint64(uintptr(unsafe.Pointer(rw))), n == rwmutexMaxReaders)
+func (rw *RWMutex) onReleaseLocked(gid int64, n int64) error {
+	return rw.t.onReleaseLocked(gid, rw.resourceID(), n == rwmutexMaxReaders, n)
 }
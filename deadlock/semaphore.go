@@ -9,20 +9,33 @@ import (
 	"container/list"
 	"context"
 	"sync"
+	"time"
+
+	"github.com/petermattis/goid"
 )
 
 type waiter struct {
 	n     int64
+	gid   int64
 	ready chan<- struct{} // Closed when semaphore acquired.
 }
 
+// gid is the goroutine on whose behalf the callback is called,
+// which is not necessarily the current goroutine, e.g., notifyWaiters.
+// 0 means the current goroutine, so that goid is only resolved when needed,
+// unknownGID means a waiter started waiting while detection is disabled.
+// errors returned are reported after the lock of Weighted is released.
 type callback interface {
-	onAcquiredLocked(n int64)
-	onWaitLocked(n int64)
-	onWaitCanceledLocked(n int64)
-	onReleaseLocked(n int64)
+	onAcquiredLocked(gid int64, n int64) error
+	onWaitLocked(gid int64, n int64) error
+	onWaitCanceledLocked(gid int64, n int64)
+	// called without lock after acquired by waiting
+	onWaited(n int64, waited time.Duration)
+	onReleaseLocked(gid int64, n int64) error
 }
 
+const unknownGID = -1
+
 // NewWeighted creates a new weighted semaphore with the given
 // maximum combined weight for concurrent access.
 func NewWeighted(n int64, cb callback) *Weighted {
@@ -49,8 +62,9 @@ func (s *Weighted) Acquire(ctx context.Context, n int64) error {
 	s.mu.Lock()
 	if s.size-s.cur >= n && s.waiters.Len() == 0 {
 		s.cur += n
-		s.cb.onAcquiredLocked(n)
+		err := s.cb.onAcquiredLocked(0, n)
 		s.mu.Unlock()
+		reportAll(err)
 		return nil
 	}
 
@@ -60,47 +74,95 @@ func (s *Weighted) Acquire(ctx context.Context, n int64) error {
 		panic("semaphore: acquire more than size")
 	}
 
+	// near zero overhead when detection and profiling are off
+	gid := int64(unknownGID)
+	if Enabled() {
+		gid = goid.Get()
+	}
+	var start time.Time
+	if profiling() {
+		start = time.Now()
+	}
 	ready := make(chan struct{})
-	w := waiter{n: n, ready: ready}
+	w := waiter{n: n, gid: gid, ready: ready}
 	elem := s.waiters.PushBack(w)
-	s.cb.onWaitLocked(n)
+	err := s.cb.onWaitLocked(gid, n)
 	s.mu.Unlock()
+	if err != nil {
+		s.reportWait(err, elem, ready, gid, n)
+	}
 
 	select {
 	case <-ctx.Done():
 		err := ctx.Err()
-		s.mu.Lock()
-		select {
-		case <-ready:
+		if s.cancelWait(elem, ready, gid, n) {
 			// Acquired the semaphore after we were canceled.  Rather than trying to
 			// fix up the queue, just pretend we didn't notice the cancelation.
 			err = nil
-		default:
-			isFront := s.waiters.Front() == elem
-			s.waiters.Remove(elem)
-			if isFront && s.size > s.cur {
-				s.notifyWaiters()
-			}
-			s.cb.onWaitCanceledLocked(n)
+			s.onWaited(n, start)
 		}
-		s.mu.Unlock()
 		return err
 
 	case <-ready:
+		s.onWaited(n, start)
 		return nil
 	}
 }
 
+func (s *Weighted) onWaited(n int64, start time.Time) {
+	if !start.IsZero() {
+		s.cb.onWaited(n, time.Since(start))
+	}
+}
+
+// reportWait reports err found when the wait starts,
+// the wait is given up if err is reported by panic.
+func (s *Weighted) reportWait(err error, elem *list.Element, ready chan struct{}, gid, n int64) {
+	defer func() {
+		if r := recover(); r != nil {
+			if s.cancelWait(elem, ready, gid, n) {
+				s.Release(n)
+			}
+			panic(r)
+		}
+	}()
+
+	report(err)
+}
+
+// cancelWait removes the waiter, acquired is true if it's too late
+func (s *Weighted) cancelWait(elem *list.Element, ready chan struct{}, gid, n int64) (acquired bool) {
+	var errs []error
+	s.mu.Lock()
+	select {
+	case <-ready:
+		acquired = true
+	default:
+		isFront := s.waiters.Front() == elem
+		s.waiters.Remove(elem)
+		if isFront && s.size > s.cur {
+			errs = s.notifyWaiters()
+		}
+		s.cb.onWaitCanceledLocked(gid, n)
+	}
+	s.mu.Unlock()
+
+	reportAll(errs...)
+	return
+}
+
 // TryAcquire acquires the semaphore with a weight of n without blocking.
 // On success, returns true. On failure, returns false and leaves the semaphore unchanged.
 func (s *Weighted) TryAcquire(n int64) bool {
 	s.mu.Lock()
 	success := s.size-s.cur >= n && s.waiters.Len() == 0
+	var err error
 	if success {
-		s.cb.onAcquiredLocked(n)
+		err = s.cb.onAcquiredLocked(0, n)
 		s.cur += n
 	}
 	s.mu.Unlock()
+	reportAll(err)
 	return success
 }
 
@@ -112,12 +174,15 @@ func (s *Weighted) Release(n int64) {
 		s.mu.Unlock()
 		panic("semaphore: released more than held")
 	}
-	s.cb.onReleaseLocked(n)
-	s.notifyWaiters()
+	err := s.cb.onReleaseLocked(0, n)
+	errs := s.notifyWaiters()
 	s.mu.Unlock()
+
+	reportAll(append(errs, err)...)
 }
 
-func (s *Weighted) notifyWaiters() {
+// notifyWaiters returns errors found when acquiring on behalf of the waiters
+func (s *Weighted) notifyWaiters() (errs []error) {
 	for {
 		next := s.waiters.Front()
 		if next == nil {
@@ -142,7 +207,10 @@ func (s *Weighted) notifyWaiters() {
 
 		s.cur += w.n
 		s.waiters.Remove(next)
-		s.cb.onAcquiredLocked(w.n)
+		if err := s.cb.onAcquiredLocked(w.gid, w.n); err != nil {
+			errs = append(errs, err)
+		}
 		close(w.ready)
 	}
+	return
 }
@@ -0,0 +1,123 @@
+package deadlock
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/petermattis/goid"
+)
+
+var disabled uint32
+
+// Enable detection, which is the default
+func Enable() {
+	atomic.StoreUint32(&disabled, 0)
+}
+
+// Disable detection, locks then cost about the same as a plain semaphore,
+// so that they can be left in production builds.
+// Locks acquired while disabled are not tracked even after Enable.
+func Disable() {
+	atomic.StoreUint32(&disabled, 1)
+}
+
+// Enabled tells whether detection is enabled
+func Enabled() bool {
+	return atomic.LoadUint32(&disabled) == 0
+}
+
+// tracker sits between a lock and the detector,
+// so that holds acquired while disabled never reach the detector.
+// it's guarded by the mutex of Weighted.
+// gid 0 means the current goroutine, see callback.
+type tracker struct {
+	tracked   int64 // weight acquired while enabled
+	untracked int64 // weight acquired while disabled
+	waiting   int   // waits registered in detector
+	profiled  int   // holds acquired while profiling
+	site      uintptr
+	id        uint64 // resourceID of the lock
+}
+
+func (t *tracker) onAcquiredLocked(gid int64, resourceID uint64, w bool, n int64) (err error) {
+	if profiling() {
+		t.profiled++
+		statsOf(t.site).onAcquired()
+	}
+
+	if !Enabled() || gid == unknownGID {
+		if t.waiting > 0 && gid != unknownGID && d.onWaitDoneLocked(resolveGID(gid), resourceID) {
+			t.waiting--
+		}
+		t.untracked += n
+		return
+	}
+
+	gid = resolveGID(gid)
+	waited, err := d.onAcquiredLocked(gid, resourceID, w, t.site)
+	if waited {
+		t.waiting--
+	}
+	t.tracked += n
+	return
+}
+
+func (t *tracker) onWaitLocked(gid int64, resourceID uint64, w bool) (err error) {
+	if !Enabled() || gid == unknownGID {
+		return
+	}
+
+	gid = resolveGID(gid)
+	waiting, err := d.onWaitLocked(gid, resourceID, w, t.site)
+	if waiting {
+		t.waiting++
+	}
+	return
+}
+
+func (t *tracker) onWaitCanceledLocked(gid int64, resourceID uint64) {
+	if t.waiting > 0 && gid != unknownGID && d.onWaitDoneLocked(resolveGID(gid), resourceID) {
+		t.waiting--
+	}
+}
+
+func (t *tracker) onWaited(waited time.Duration) {
+	if profiling() {
+		statsOf(t.site).onWaited(waited)
+	}
+}
+
+func (t *tracker) onReleaseLocked(gid int64, resourceID uint64, w bool, n int64) (err error) {
+	if t.profiled > 0 {
+		t.profiled--
+		statsOf(t.site).onReleased()
+	}
+
+	if t.tracked >= n && d.onReleaseLocked(resolveGID(gid), resourceID, w) {
+		t.tracked -= n
+		return
+	}
+
+	if t.untracked >= n {
+		t.untracked -= n
+		return
+	}
+
+	// released by a goroutine not owning it, forget an owner
+	// so that it won't be mistaken as holding the lock
+	if t.tracked >= n && d.releaseAnyOwner(resourceID, w) {
+		t.tracked -= n
+	}
+
+	if Enabled() {
+		err = newErrorUsage("releasing a lock not owned")
+	}
+	return
+}
+
+func resolveGID(gid int64) int64 {
+	if gid == 0 {
+		return goid.Get()
+	}
+	return gid
+}
@@ -0,0 +1,86 @@
+package deadlock
+
+import (
+	"sync"
+
+	"github.com/petermattis/goid"
+)
+
+// WaitGroup is like sync.WaitGroup, goroutines started by Go are tracked
+// as holders of the WaitGroup until they return, so that Wait is part of
+// the wait-for graph like acquiring a Mutex held by them.
+// Add/Done are not tracked since the goroutine calling Done is unknown.
+type WaitGroup struct {
+	wg   sync.WaitGroup
+	once sync.Once
+	site uintptr
+	id   uint64
+}
+
+// NewWaitGroup is ctor for WaitGroup, the zero value is also usable
+func NewWaitGroup() *WaitGroup {
+	wg := &WaitGroup{}
+	wg.initSite(callerPC(1))
+	return wg
+}
+
+// Add is the same as sync.WaitGroup.Add
+func (wg *WaitGroup) Add(delta int) {
+	wg.wg.Add(delta)
+}
+
+// Done is the same as sync.WaitGroup.Done
+func (wg *WaitGroup) Done() {
+	wg.wg.Done()
+}
+
+// Go runs f in a new goroutine tracked by wg
+func (wg *WaitGroup) Go(f func()) {
+	wg.initSite(callerPC(1))
+	wg.wg.Add(1)
+	go func() {
+		defer wg.wg.Done()
+
+		if !Enabled() {
+			f()
+			return
+		}
+
+		gid := goid.Get()
+		_, err := d.onAcquiredLocked(gid, wg.resourceID(), false, wg.site)
+		defer d.onReleaseLocked(gid, wg.resourceID(), false)
+		if err != nil {
+			report(err)
+		}
+		f()
+	}()
+}
+
+// Wait blocks until all goroutines are done
+func (wg *WaitGroup) Wait() {
+	if !Enabled() {
+		wg.wg.Wait()
+		return
+	}
+
+	wg.initSite(callerPC(1))
+	gid := goid.Get()
+	_, err := d.onWaitLocked(gid, wg.resourceID(), true, wg.site)
+	defer d.onWaitDoneLocked(gid, wg.resourceID())
+	if err != nil {
+		report(err)
+	}
+	wg.wg.Wait()
+}
+
+// the site of a zero value WaitGroup is where it's first used
+func (wg *WaitGroup) initSite(site uintptr) {
+	wg.once.Do(func() {
+		wg.site = site
+		wg.id = newResourceID()
+	})
+}
+
+func (wg *WaitGroup) resourceID() uint64 {
+	return wg.id
+}
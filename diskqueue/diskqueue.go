@@ -31,6 +31,7 @@ type StreamBytes struct {
 type queueInterface interface {
 	queueMetaROInterface
 	Put([]byte) (int64, error)
+	PutSync([]byte) (int64, error)
 	Read(ctx context.Context, offset int64) ([]byte, error)
 	StreamRead(ctx context.Context, offset int64) (<-chan StreamBytes, error)
 	StreamOffsetRead(offsetCh <-chan int64) (<-chan StreamBytes, error)
@@ -136,7 +137,7 @@ func (q *Queue) writeBufferPool() *sync.Pool {
 	} else {
 		pool := &sync.Pool{
 			New: func() interface{} {
-				return bytes.NewBuffer(make([]byte, q.conf.MaxFileSize))
+				return bytes.NewBuffer(make([]byte, 0, q.conf.MaxFileSize))
 			},
 		}
 		q.conf.writeBufferPool = pool
@@ -237,10 +238,12 @@ func (q *Queue) createQfile() (err error) {
 
 type writeResult struct {
 	offset int64
+	err    error
 }
 
 type writeRequest struct {
 	data   []byte
+	sync   bool
 	result chan writeResult
 }
 
@@ -288,9 +291,11 @@ func (q *Queue) handleWriteAndGC() {
 		actualSizeLength = sizeLength
 	}
 
+	var wroteFiles []*qfile
 	handleWriteFunc := func() {
 		// enough data, ready to go!
 		qf = q.files[len(q.files)-1]
+		wroteFiles = append(wroteFiles[:0], qf)
 
 		writeBuffs := q.writeBuffs
 
@@ -304,6 +309,7 @@ func (q *Queue) handleWriteAndGC() {
 					logger.Instance().Error("handleWriteAndGC createQfile", zap.Error(err))
 				} else {
 					qf = q.files[len(q.files)-1]
+					wroteFiles = append(wroteFiles, qf)
 					wroteN, err = qf.writeBuffers(&q.writeBuffs)
 					totalN += wroteN
 				}
@@ -322,10 +328,24 @@ func (q *Queue) handleWriteAndGC() {
 
 		q.writeBuffs = writeBuffs
 
+		var syncErr error
+		for _, req := range q.writeReqs {
+			if req.sync {
+				syncErr = q.syncFiles(wroteFiles)
+				break
+			}
+		}
+
 		// 全部写入成功
 		for _, req := range q.writeReqs {
-			req.result <- writeResult{offset: startWrotePosition}
-			startWrotePosition += actualSizeLength + int64(len(req.data))
+			// req is reused once result is received, so size is taken before
+			size := actualSizeLength + int64(len(req.data))
+			if req.sync {
+				req.result <- writeResult{offset: startWrotePosition, err: syncErr}
+			} else {
+				req.result <- writeResult{offset: startWrotePosition}
+			}
+			startWrotePosition += size
 		}
 		totalN = 0
 	}
@@ -444,8 +464,32 @@ func (q *Queue) handleCommit() {
 	}
 }
 
+// syncFiles makes the data written to files and the meta durable
+func (q *Queue) syncFiles(files []*qfile) (err error) {
+	for _, qf := range files {
+		if q.conf.EnableWriteBuffer {
+			q.wm.Done(qf.Commit())
+		}
+		err = qf.Sync()
+		if err != nil {
+			return
+		}
+	}
+	err = q.meta.Sync()
+	return
+}
+
 // Put data to queue
 func (q *Queue) Put(data []byte) (offset int64, err error) {
+	return q.put(data, false)
+}
+
+// PutSync is Put that returns after data is synced to disk
+func (q *Queue) PutSync(data []byte) (offset int64, err error) {
+	return q.put(data, true)
+}
+
+func (q *Queue) put(data []byte, sync bool) (offset int64, err error) {
 
 	if !q.conf.customDecoder && len(data) > q.conf.MaxMsgSize {
 		err = errMsgTooLarge
@@ -466,6 +510,7 @@ func (q *Queue) Put(data []byte) (offset int64, err error) {
 
 	wreq := wreqPool.Get().(*writeRequest)
 	wreq.data = data
+	wreq.sync = sync
 	if len(wreq.result) > 0 {
 		<-wreq.result
 	}
@@ -475,7 +520,7 @@ func (q *Queue) Put(data []byte) (offset int64, err error) {
 		result := <-wreq.result
 		wreq.data = nil
 		wreqPool.Put(wreq)
-		offset = result.offset
+		offset, err = result.offset, result.err
 		return
 	case <-q.closer.ClosedSignal():
 		err = errAlreadyClosed
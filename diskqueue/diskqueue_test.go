@@ -3,6 +3,7 @@ package diskqueue
 import (
 	"bytes"
 	"context"
+	"sync"
 	"testing"
 
 	"gotest.tools/assert"
@@ -118,3 +119,37 @@ func TestFixedQueue(t *testing.T) {
 	assert.Assert(t, err == nil && n == 0)
 
 }
+
+func TestPutSync(t *testing.T) {
+	conf := Conf{Directory: "/tmp/dqsync", WriteMmap: true, MaxFileSize: 1 << 20, EnableWriteBuffer: true, CommitInterval: 3600}
+	q, err := New(conf)
+	assert.Assert(t, err == nil)
+	defer func() {
+		err = q.Delete()
+		assert.Assert(t, err == nil)
+	}()
+
+	// synced data is committed without waiting for CommitInterval
+	testData := []byte("abcd")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				put := q.Put
+				if (i+j)%2 == 0 {
+					put = q.PutSync
+				}
+				offset, err := put(testData)
+				assert.Assert(t, err == nil, "%v", err)
+				if (i+j)%2 == 0 {
+					readData, err := q.Read(context.Background(), offset)
+					assert.Assert(t, err == nil && bytes.Equal(readData, testData), "%v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Assert(t, q.FileMeta(0).MsgCount == 1000)
+}
@@ -0,0 +1,152 @@
+package lf
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// history checker for linearizability, based on Wing & Gong with memoization
+
+const (
+	opPut = iota
+	opGet
+)
+
+type op struct {
+	call, ret int64
+	kind      int
+	in        int
+	out       int
+	ok        bool
+}
+
+// step applies o to the sequential model, returns false if o is illegal in state
+type step func(state []int, o op) ([]int, bool)
+
+type recorder struct {
+	clock int64
+}
+
+func (r *recorder) record(kind, in int, f func(in int) (out int, ok bool)) (o op) {
+	o.kind = kind
+	o.in = in
+	o.call = atomic.AddInt64(&r.clock, 1)
+	o.out, o.ok = f(in)
+	o.ret = atomic.AddInt64(&r.clock, 1)
+	return
+}
+
+// runHistory runs workers*opsPerWorker random put/get concurrently and returns the history
+func runHistory(workers, opsPerWorker int, put, get func(in int) (int, bool)) []op {
+	var (
+		r   recorder
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		ops []op
+	)
+
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+
+			local := make([]op, 0, opsPerWorker)
+			for i := 0; i < opsPerWorker; i++ {
+				if rand.Intn(2) == 0 {
+					local = append(local, r.record(opPut, w*opsPerWorker+i, put))
+				} else {
+					local = append(local, r.record(opGet, 0, get))
+				}
+			}
+
+			mu.Lock()
+			ops = append(ops, local...)
+			mu.Unlock()
+		}(w)
+	}
+	wg.Wait()
+
+	return ops
+}
+
+func linearizable(ops []op, fn step) bool {
+	if len(ops) > 64 {
+		panic("history too long")
+	}
+
+	failed := make(map[string]bool)
+	all := uint64(1)<<uint(len(ops)) - 1
+
+	var search func(done uint64, state []int) bool
+	search = func(done uint64, state []int) bool {
+		if done == all {
+			return true
+		}
+
+		key := fmt.Sprint(done, state)
+		if failed[key] {
+			return false
+		}
+
+		// an op can be linearized next only if it's invoked before every pending op returns
+		minRet := int64(-1)
+		for i, o := range ops {
+			if done&(1<<uint(i)) == 0 && (minRet < 0 || o.ret < minRet) {
+				minRet = o.ret
+			}
+		}
+
+		for i, o := range ops {
+			if done&(1<<uint(i)) != 0 || o.call > minRet {
+				continue
+			}
+			next, ok := fn(state, o)
+			if ok && search(done|1<<uint(i), next) {
+				return true
+			}
+		}
+
+		failed[key] = true
+		return false
+	}
+
+	return search(0, nil)
+}
+
+func fifoStep(capacity int) step {
+	return func(state []int, o op) ([]int, bool) {
+		switch o.kind {
+		case opPut:
+			if capacity > 0 && len(state) == capacity {
+				return state, !o.ok
+			}
+			if !o.ok {
+				return state, false
+			}
+			next := make([]int, len(state), len(state)+1)
+			copy(next, state)
+			return append(next, o.in), true
+		default:
+			if len(state) == 0 {
+				return state, !o.ok
+			}
+			return state[1:], o.ok && o.out == state[0]
+		}
+	}
+}
+
+func lifoStep(state []int, o op) ([]int, bool) {
+	switch o.kind {
+	case opPut:
+		next := make([]int, len(state), len(state)+1)
+		copy(next, state)
+		return append(next, o.in), true
+	default:
+		if len(state) == 0 {
+			return state, !o.ok
+		}
+		return state[:len(state)-1], o.ok && o.out == state[len(state)-1]
+	}
+}
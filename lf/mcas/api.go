@@ -4,8 +4,12 @@ import "unsafe"
 
 // CompareAndSwap for multiple pointer type variables
 func CompareAndSwap(a []*unsafe.Pointer, e []unsafe.Pointer, n []unsafe.Pointer) (swapped bool) {
-	d := are.putMCDesc()
-	*d = mcDesc{a: a, e: e, n: n, s: undecided}
+	d := &mcDesc{
+		a: append([]*unsafe.Pointer(nil), a...),
+		e: append([]unsafe.Pointer(nil), e...),
+		n: append([]unsafe.Pointer(nil), n...),
+		s: undecided,
+	}
 	/* Memory locations must be sorted into address order. */
 	d.sortAddr()
 	swapped = d.mcasHelp()
@@ -15,7 +19,7 @@ func CompareAndSwap(a []*unsafe.Pointer, e []unsafe.Pointer, n []unsafe.Pointer)
 // Read for a mcas consistent view
 func Read(a *unsafe.Pointer) (v unsafe.Pointer) {
 
-	for v = ccasRead(a); isMCDesc(v); ccasRead(a) {
+	for v = ccasRead(a); isMCDesc(v); v = ccasRead(a) {
 		mcfromPointer(v).mcasHelp()
 	}
 
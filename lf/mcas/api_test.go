@@ -38,4 +38,13 @@ func TestMCAS(t *testing.T) {
 	p1v = Read(&p1)
 	p2v = Read(&p2)
 	assert.Assert(t, p1v == unsafe.Pointer(&v3) && p2v == unsafe.Pointer(&v4))
+
+	// e and n follow a when sorted by address
+	a = []*unsafe.Pointer{&p2, &p1}
+	e = []unsafe.Pointer{unsafe.Pointer(&v4), unsafe.Pointer(&v3)}
+	n = []unsafe.Pointer{unsafe.Pointer(&v2), unsafe.Pointer(&v1)}
+	swapped = CompareAndSwap(a, e, n)
+	assert.Assert(t, swapped)
+	assert.Assert(t, Read(&p1) == unsafe.Pointer(&v1) && Read(&p2) == unsafe.Pointer(&v2))
+	assert.Assert(t, a[0] == &p2)
 }
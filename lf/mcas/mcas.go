@@ -6,6 +6,8 @@ import (
 	"unsafe"
 )
 
+// descriptors are allocated on heap, the tagged pointers installed
+// in memory locations keep them alive for helpers
 type mcDesc struct {
 	a []*unsafe.Pointer
 	e []unsafe.Pointer
@@ -30,19 +32,30 @@ const (
 )
 
 func mcfromPointer(v unsafe.Pointer) *mcDesc {
-	ptr := uintptr(v)
-	ptr = ptr & ^uintptr(addrMask)
-	return (*mcDesc)(are.getPointer(ptr))
+	return (*mcDesc)(unsafe.Pointer(uintptr(v) &^ addrMask))
 }
 
 func (d *mcDesc) toPointer() unsafe.Pointer {
 	return unsafe.Pointer(uintptr(unsafe.Pointer(d)) + uintptr(mcDescAddr))
 }
 
+// sortAddr sorts a, e and n together
 func (d *mcDesc) sortAddr() {
-	sort.Slice(d.a, func(i, j int) bool {
-		return uintptr(unsafe.Pointer(d.a[i])) < uintptr(unsafe.Pointer(d.a[j]))
-	})
+	sort.Sort(byAddr{d})
+}
+
+type byAddr struct{ d *mcDesc }
+
+func (s byAddr) Len() int { return len(s.d.a) }
+
+func (s byAddr) Less(i, j int) bool {
+	return uintptr(unsafe.Pointer(s.d.a[i])) < uintptr(unsafe.Pointer(s.d.a[j]))
+}
+
+func (s byAddr) Swap(i, j int) {
+	s.d.a[i], s.d.a[j] = s.d.a[j], s.d.a[i]
+	s.d.e[i], s.d.e[j] = s.d.e[j], s.d.e[i]
+	s.d.n[i], s.d.n[j] = s.d.n[j], s.d.n[i]
 }
 
 func (d *mcDesc) status() uint32 {
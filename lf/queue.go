@@ -0,0 +1,77 @@
+// Package lf provides generic lock free containers, the mcas subpackage provides multi word CAS.
+package lf
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+type queueNode[T any] struct {
+	value T
+	next  unsafe.Pointer // *queueNode[T]
+}
+
+// Queue is an unbounded MPMC lock free queue (Michael–Scott)
+// Enqueue links the node after tail, then swings tail, which is helped by others if lagging.
+// zero value is not usable, use NewQueue instead
+type Queue[T any] struct {
+	head unsafe.Pointer // *queueNode[T], always points to a dummy node
+	_    [cacheLinePad]byte
+	tail unsafe.Pointer // *queueNode[T], the last node or the one before it
+}
+
+// NewQueue is ctor for Queue
+func NewQueue[T any]() *Queue[T] {
+	dummy := unsafe.Pointer(&queueNode[T]{})
+	return &Queue[T]{head: dummy, tail: dummy}
+}
+
+// Enqueue v to the tail of queue
+func (q *Queue[T]) Enqueue(v T) {
+	n := unsafe.Pointer(&queueNode[T]{value: v})
+	for {
+		tail := atomic.LoadPointer(&q.tail)
+		next := atomic.LoadPointer(&(*queueNode[T])(tail).next)
+		if next != nil {
+			// help the lagging tail
+			atomic.CompareAndSwapPointer(&q.tail, tail, next)
+			continue
+		}
+		if atomic.CompareAndSwapPointer(&(*queueNode[T])(tail).next, nil, n) {
+			atomic.CompareAndSwapPointer(&q.tail, tail, n)
+			return
+		}
+	}
+}
+
+// Dequeue from the head of queue, ok is false if queue is empty
+func (q *Queue[T]) Dequeue() (v T, ok bool) {
+	for {
+		head := atomic.LoadPointer(&q.head)
+		tail := atomic.LoadPointer(&q.tail)
+		next := atomic.LoadPointer(&(*queueNode[T])(head).next)
+		if next == nil {
+			return
+		}
+		if head == tail {
+			// head never passes tail
+			atomic.CompareAndSwapPointer(&q.tail, tail, next)
+			continue
+		}
+
+		if atomic.CompareAndSwapPointer(&q.head, head, next) {
+			// next becomes the dummy, only the winner accesses its value
+			n := (*queueNode[T])(next)
+			v, ok = n.value, true
+			var zero T
+			n.value = zero
+			return
+		}
+	}
+}
+
+// Empty tells whether queue is empty at the moment
+func (q *Queue[T]) Empty() bool {
+	head := atomic.LoadPointer(&q.head)
+	return atomic.LoadPointer(&(*queueNode[T])(head).next) == nil
+}
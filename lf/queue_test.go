@@ -0,0 +1,101 @@
+package lf
+
+import (
+	"sync"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestQueue(t *testing.T) {
+	q := NewQueue[int]()
+	assert.Assert(t, q.Empty())
+
+	_, ok := q.Dequeue()
+	assert.Assert(t, !ok)
+
+	n := 100
+	for i := 0; i < n; i++ {
+		q.Enqueue(i)
+	}
+	for i := 0; i < n; i++ {
+		v, ok := q.Dequeue()
+		assert.Assert(t, ok && v == i, "v:%v i:%v", v, i)
+	}
+	assert.Assert(t, q.Empty())
+}
+
+func TestQueueDequeueReleases(t *testing.T) {
+	q := NewQueue[*int]()
+	q.Enqueue(new(int))
+	_, ok := q.Dequeue()
+	assert.Assert(t, ok)
+
+	// the dummy node doesn't keep the dequeued value reachable
+	assert.Assert(t, (*queueNode[*int])(q.head).value == nil)
+}
+
+func TestQueueLinearizable(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		q := NewQueue[int]()
+		ops := runHistory(4, 5, func(in int) (int, bool) {
+			q.Enqueue(in)
+			return 0, true
+		}, func(int) (int, bool) {
+			return q.Dequeue()
+		})
+		assert.Assert(t, linearizable(ops, fifoStep(0)), "%v", ops)
+	}
+}
+
+func TestLinearizableChecker(t *testing.T) {
+	// put(1) and put(2) are sequential, so get must return 1
+	ops := []op{
+		{call: 1, ret: 2, kind: opPut, in: 1, ok: true},
+		{call: 3, ret: 4, kind: opPut, in: 2, ok: true},
+		{call: 5, ret: 6, kind: opGet, out: 2, ok: true},
+	}
+	assert.Assert(t, !linearizable(ops, fifoStep(0)))
+	assert.Assert(t, linearizable(ops, lifoStep))
+
+	// put(1) and put(2) are concurrent, so get can return either
+	ops[1].call = 1
+	assert.Assert(t, linearizable(ops, fifoStep(0)))
+}
+
+func BenchmarkQueue(b *testing.B) {
+	q := NewQueue[int]()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			q.Enqueue(1)
+			q.Dequeue()
+		}
+	})
+}
+
+func BenchmarkChanQueue(b *testing.B) {
+	ch := make(chan int, 1024)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ch <- 1
+			<-ch
+		}
+	})
+}
+
+func BenchmarkMutexQueue(b *testing.B) {
+	var (
+		mu sync.Mutex
+		s  []int
+	)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mu.Lock()
+			s = append(s, 1)
+			mu.Unlock()
+			mu.Lock()
+			s = s[1:]
+			mu.Unlock()
+		}
+	})
+}
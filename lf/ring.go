@@ -0,0 +1,126 @@
+package lf
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+const cacheLinePad = 64
+
+type ringCell[T any] struct {
+	seq   uint64
+	value T
+}
+
+// Ring is a bounded MPMC ring buffer (Vyukov)
+// unlike the original algorithm, Enqueue/Dequeue only report full/empty when
+// the ring really is, instead of when a peer is in the middle of an operation on
+// the same cell, which keeps them linearizable. The price is that they wait for
+// such a peer, so Ring is not lock free.
+type Ring[T any] struct {
+	_      [cacheLinePad]byte
+	enqPos uint64
+	_      [cacheLinePad - 8]byte
+	deqPos uint64
+	_      [cacheLinePad - 8]byte
+	mask   uint64
+	cells  []ringCell[T]
+}
+
+// NewRing is ctor for Ring
+// capacity is rounded up to the next power of 2
+func NewRing[T any](capacity int) *Ring[T] {
+	if capacity <= 0 {
+		panic("capacity <= 0")
+	}
+
+	size := uint64(1)
+	for size < uint64(capacity) {
+		size <<= 1
+	}
+
+	r := &Ring[T]{mask: size - 1, cells: make([]ringCell[T], size)}
+	for i := range r.cells {
+		r.cells[i].seq = uint64(i)
+	}
+	return r
+}
+
+// Cap returns the capacity of ring
+func (r *Ring[T]) Cap() int {
+	return len(r.cells)
+}
+
+// Enqueue returns false if ring is full
+func (r *Ring[T]) Enqueue(v T) bool {
+	pos := atomic.LoadUint64(&r.enqPos)
+	for {
+		cell := &r.cells[pos&r.mask]
+		seq := atomic.LoadUint64(&cell.seq)
+		switch diff := int64(seq) - int64(pos); {
+		case diff == 0:
+			// cell is free for pos, try to claim it
+			if atomic.CompareAndSwapUint64(&r.enqPos, pos, pos+1) {
+				cell.value = v
+				// publish to Dequeue
+				atomic.StoreUint64(&cell.seq, pos+1)
+				return true
+			}
+			pos = atomic.LoadUint64(&r.enqPos)
+		case diff < 0:
+			// cell not released yet from the previous lap
+			if atomic.LoadUint64(&r.deqPos)+r.mask+1 == pos {
+				return false
+			}
+			// some Dequeue has claimed the cell but not released it yet
+			runtime.Gosched()
+			pos = atomic.LoadUint64(&r.enqPos)
+		default:
+			// someone else claimed pos
+			pos = atomic.LoadUint64(&r.enqPos)
+		}
+	}
+}
+
+// Dequeue returns false if ring is empty
+func (r *Ring[T]) Dequeue() (v T, ok bool) {
+	pos := atomic.LoadUint64(&r.deqPos)
+	for {
+		cell := &r.cells[pos&r.mask]
+		seq := atomic.LoadUint64(&cell.seq)
+		switch diff := int64(seq) - int64(pos+1); {
+		case diff == 0:
+			// cell is published for pos, try to claim it
+			if atomic.CompareAndSwapUint64(&r.deqPos, pos, pos+1) {
+				var zero T
+				v, cell.value = cell.value, zero
+				ok = true
+				// release the cell to the next lap of Enqueue
+				atomic.StoreUint64(&cell.seq, pos+r.mask+1)
+				return
+			}
+			pos = atomic.LoadUint64(&r.deqPos)
+		case diff < 0:
+			// cell not published yet
+			if atomic.LoadUint64(&r.enqPos) == pos {
+				return
+			}
+			// some Enqueue has claimed the cell but not published it yet
+			runtime.Gosched()
+			pos = atomic.LoadUint64(&r.deqPos)
+		default:
+			// someone else claimed pos
+			pos = atomic.LoadUint64(&r.deqPos)
+		}
+	}
+}
+
+// Len returns the approximate number of elements
+func (r *Ring[T]) Len() int {
+	deqPos := atomic.LoadUint64(&r.deqPos)
+	enqPos := atomic.LoadUint64(&r.enqPos)
+	if enqPos < deqPos {
+		return 0
+	}
+	return int(enqPos - deqPos)
+}
@@ -0,0 +1,68 @@
+package lf
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestRing(t *testing.T) {
+	r := NewRing[int](3)
+	assert.Assert(t, r.Cap() == 4)
+
+	_, ok := r.Dequeue()
+	assert.Assert(t, !ok)
+
+	// wrap around a few laps
+	for lap := 0; lap < 3; lap++ {
+		for i := 0; i < r.Cap(); i++ {
+			assert.Assert(t, r.Enqueue(i))
+		}
+		assert.Assert(t, !r.Enqueue(r.Cap()) && r.Len() == r.Cap())
+
+		for i := 0; i < r.Cap(); i++ {
+			v, ok := r.Dequeue()
+			assert.Assert(t, ok && v == i, "v:%v i:%v", v, i)
+		}
+		_, ok = r.Dequeue()
+		assert.Assert(t, !ok && r.Len() == 0)
+	}
+}
+
+func TestRingLinearizable(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		r := NewRing[int](2)
+		ops := runHistory(4, 5, func(in int) (int, bool) {
+			return 0, r.Enqueue(in)
+		}, func(int) (int, bool) {
+			return r.Dequeue()
+		})
+		assert.Assert(t, linearizable(ops, fifoStep(r.Cap())), "%v", ops)
+	}
+}
+
+func BenchmarkRing(b *testing.B) {
+	r := NewRing[int](1024)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Enqueue(1)
+			r.Dequeue()
+		}
+	})
+}
+
+func BenchmarkChanRing(b *testing.B) {
+	ch := make(chan int, 1024)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			select {
+			case ch <- 1:
+			default:
+			}
+			select {
+			case <-ch:
+			default:
+			}
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package lf
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+type stackNode[T any] struct {
+	value T
+	next  *stackNode[T] // immutable once pushed
+}
+
+// Stack is an unbounded lock free stack (Treiber)
+// zero value is an empty stack
+type Stack[T any] struct {
+	top unsafe.Pointer // *stackNode[T]
+}
+
+// NewStack is ctor for Stack
+func NewStack[T any]() *Stack[T] {
+	return &Stack[T]{}
+}
+
+// Push v onto the stack
+func (s *Stack[T]) Push(v T) {
+	n := &stackNode[T]{value: v}
+	for {
+		top := atomic.LoadPointer(&s.top)
+		n.next = (*stackNode[T])(top)
+		if atomic.CompareAndSwapPointer(&s.top, top, unsafe.Pointer(n)) {
+			return
+		}
+	}
+}
+
+// Pop from the stack, ok is false if stack is empty
+// ABA is not a problem since nodes are never reused
+func (s *Stack[T]) Pop() (v T, ok bool) {
+	for {
+		top := atomic.LoadPointer(&s.top)
+		if top == nil {
+			return
+		}
+
+		n := (*stackNode[T])(top)
+		if atomic.CompareAndSwapPointer(&s.top, top, unsafe.Pointer(n.next)) {
+			v, ok = n.value, true
+			return
+		}
+	}
+}
+
+// Empty tells whether stack is empty at the moment
+func (s *Stack[T]) Empty() bool {
+	return atomic.LoadPointer(&s.top) == nil
+}
@@ -0,0 +1,58 @@
+package lf
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestStack(t *testing.T) {
+	var s Stack[int]
+	assert.Assert(t, s.Empty())
+
+	_, ok := s.Pop()
+	assert.Assert(t, !ok)
+
+	n := 100
+	for i := 0; i < n; i++ {
+		s.Push(i)
+	}
+	for i := n - 1; i >= 0; i-- {
+		v, ok := s.Pop()
+		assert.Assert(t, ok && v == i, "v:%v i:%v", v, i)
+	}
+	assert.Assert(t, s.Empty())
+}
+
+func TestStackLinearizable(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		s := NewStack[int]()
+		ops := runHistory(4, 5, func(in int) (int, bool) {
+			s.Push(in)
+			return 0, true
+		}, func(int) (int, bool) {
+			return s.Pop()
+		})
+		assert.Assert(t, linearizable(ops, lifoStep), "%v", ops)
+	}
+}
+
+func BenchmarkStack(b *testing.B) {
+	s := NewStack[int]()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.Push(1)
+			s.Pop()
+		}
+	})
+}
+
+func BenchmarkChanStack(b *testing.B) {
+	ch := make(chan int, 1024)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ch <- 1
+			<-ch
+		}
+	})
+}
@@ -399,7 +399,7 @@ func (f *File) Shrink() (err error) {
 // Sync from os to disk
 func (f *File) Sync() (err error) {
 	if f.wmm {
-		err = util.MSync(f.fmap, 0, len(f.fmap))
+		err = util.MSync(f.fmap, int64(len(f.fmap)), syscall.MS_SYNC)
 		return
 	}
 
@@ -1,6 +1,6 @@
 package monitor
 
-// this module registers metrics and pprof to http.DefaultServeMux
+// this module registers metrics, pprof and deadlock report to http.DefaultServeMux
 
 import (
 	"net/http"
@@ -8,8 +8,10 @@ import (
 	_ "net/http/pprof"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/zhiqiangxu/util/deadlock"
 )
 
 func init() {
 	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/debug/deadlock", deadlock.Handler())
 }
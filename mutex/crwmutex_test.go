@@ -65,7 +65,7 @@ func TestSemaphoreBug(t *testing.T) {
 		// start a Lock request that will giveup after 300ms
 		err := crwm.Lock(ctx)
 		if err == nil {
-			t.FailNow()
+			t.Error("Lock should be canceled")
 		}
 	}()
 
@@ -79,7 +79,7 @@ func TestSemaphoreBug(t *testing.T) {
 		// this doesn't happen because there's a bug in semaphore
 		err := crwm.RLock(context.Background())
 		if err != nil {
-			t.FailNow()
+			t.Error("RLock should succeed")
 		}
 		crwm.RUnlock()
 		close(doneCh)
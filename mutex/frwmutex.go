@@ -0,0 +1,299 @@
+package mutex
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// FRWMutex implements a fair cancelable rwmutex,
+// waiters are granted in FIFO order, consecutive readers at the head are granted together.
+// An arriving reader never bypasses a waiting writer, so writers don't starve,
+// and readers don't starve either unless PreferWriter is set.
+type FRWMutex struct {
+	mu           sync.Mutex
+	preferWriter bool
+	readers      int
+	writer       bool
+	waiters      list.List // of *frwWaiter
+	upgrader     *frwWaiter
+	stats        FRWMutexStats
+}
+
+type frwWaiter struct {
+	w     bool
+	ready chan struct{}
+}
+
+// FRWMutexStats is the wait time instrumentation of FRWMutex,
+// only acquisitions that had to wait are counted.
+type FRWMutexStats struct {
+	ReadWaits      int64
+	ReadWaitTotal  time.Duration
+	MaxReadWait    time.Duration
+	WriteWaits     int64 // including upgrades
+	WriteWaitTotal time.Duration
+	MaxWriteWait   time.Duration
+	Canceled       int64
+}
+
+// ErrUpgradeConflict is returned by TryUpgrade when another reader is upgrading,
+// waiting for it would deadlock since both hold a read lock.
+var ErrUpgradeConflict = errors.New("another upgrade is pending")
+
+// NewFRWMutex is ctor for FRWMutex
+// if preferWriter is true, a waiting writer goes ahead of all waiting readers.
+func NewFRWMutex(preferWriter bool) *FRWMutex {
+	rw := &FRWMutex{}
+	rw.Init(preferWriter)
+	return rw
+}
+
+// Init for embeded usage
+func (rw *FRWMutex) Init(preferWriter bool) {
+	rw.preferWriter = preferWriter
+	rw.waiters.Init()
+}
+
+// Lock with context
+func (rw *FRWMutex) Lock(ctx context.Context) (err error) {
+	rw.mu.Lock()
+	if rw.canLockLocked() {
+		rw.writer = true
+		rw.mu.Unlock()
+		return
+	}
+
+	waiter := &frwWaiter{w: true, ready: make(chan struct{})}
+	elem := rw.enqueueLocked(waiter)
+	rw.mu.Unlock()
+
+	err = rw.wait(ctx, waiter, elem)
+	return
+}
+
+// Unlock should only be called after a successful Lock or TryUpgrade
+func (rw *FRWMutex) Unlock() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if !rw.writer {
+		panic("Unlock of unlocked FRWMutex")
+	}
+	rw.writer = false
+	rw.notifyWaitersLocked()
+}
+
+// RLock with context
+func (rw *FRWMutex) RLock(ctx context.Context) (err error) {
+	rw.mu.Lock()
+	if rw.canRLockLocked() {
+		rw.readers++
+		rw.mu.Unlock()
+		return
+	}
+
+	waiter := &frwWaiter{ready: make(chan struct{})}
+	elem := rw.enqueueLocked(waiter)
+	rw.mu.Unlock()
+
+	err = rw.wait(ctx, waiter, elem)
+	return
+}
+
+// RUnlock should only be called after a successful RLock or Downgrade
+func (rw *FRWMutex) RUnlock() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.readers <= 0 {
+		panic("RUnlock of unlocked FRWMutex")
+	}
+	rw.readers--
+	rw.notifyWaitersLocked()
+}
+
+// TryLock returns true if lock acquired
+func (rw *FRWMutex) TryLock() bool {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if !rw.canLockLocked() {
+		return false
+	}
+	rw.writer = true
+	return true
+}
+
+// TryRLock returns true if rlock acquired
+func (rw *FRWMutex) TryRLock() bool {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if !rw.canRLockLocked() {
+		return false
+	}
+	rw.readers++
+	return true
+}
+
+// Downgrade turns the write lock into a read lock atomically,
+// waiting readers at the head are granted together.
+func (rw *FRWMutex) Downgrade() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if !rw.writer {
+		panic("Downgrade of unlocked FRWMutex")
+	}
+	rw.writer = false
+	rw.readers++
+	rw.notifyWaitersLocked()
+}
+
+// TryUpgrade turns the read lock into a write lock once the other readers are gone,
+// the upgrade goes ahead of all waiters.
+// The read lock is still held if an error is returned, which is ctx.Err()
+// or ErrUpgradeConflict when another reader is upgrading.
+func (rw *FRWMutex) TryUpgrade(ctx context.Context) (err error) {
+	rw.mu.Lock()
+	if rw.readers <= 0 {
+		rw.mu.Unlock()
+		panic("TryUpgrade of unlocked FRWMutex")
+	}
+	if rw.upgrader != nil {
+		rw.mu.Unlock()
+		err = ErrUpgradeConflict
+		return
+	}
+	if rw.readers == 1 {
+		rw.readers = 0
+		rw.writer = true
+		rw.mu.Unlock()
+		return
+	}
+
+	waiter := &frwWaiter{w: true, ready: make(chan struct{})}
+	rw.upgrader = waiter
+	rw.mu.Unlock()
+
+	err = rw.wait(ctx, waiter, nil)
+	return
+}
+
+// Stats returns the wait time instrumentation
+func (rw *FRWMutex) Stats() FRWMutexStats {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.stats
+}
+
+func (rw *FRWMutex) canLockLocked() bool {
+	return !rw.writer && rw.readers == 0 && rw.upgrader == nil && rw.waiters.Len() == 0
+}
+
+func (rw *FRWMutex) canRLockLocked() bool {
+	return !rw.writer && rw.upgrader == nil && rw.waiters.Len() == 0
+}
+
+func (rw *FRWMutex) enqueueLocked(waiter *frwWaiter) *list.Element {
+	if waiter.w && rw.preferWriter {
+		// after the waiting writers, before the waiting readers
+		for e := rw.waiters.Front(); e != nil; e = e.Next() {
+			if !e.Value.(*frwWaiter).w {
+				return rw.waiters.InsertBefore(waiter, e)
+			}
+		}
+	}
+	return rw.waiters.PushBack(waiter)
+}
+
+// elem is nil for upgrade
+func (rw *FRWMutex) wait(ctx context.Context, waiter *frwWaiter, elem *list.Element) (err error) {
+	start := time.Now()
+
+	select {
+	case <-waiter.ready:
+		rw.mu.Lock()
+		rw.onWaitedLocked(waiter.w, time.Since(start))
+		rw.mu.Unlock()
+	case <-ctx.Done():
+		err = ctx.Err()
+		rw.mu.Lock()
+		select {
+		case <-waiter.ready:
+			// acquired after canceled, just pretend we didn't notice the cancellation
+			err = nil
+			rw.onWaitedLocked(waiter.w, time.Since(start))
+		default:
+			if elem == nil {
+				rw.upgrader = nil
+			} else {
+				rw.waiters.Remove(elem)
+			}
+			rw.stats.Canceled++
+			// the followers may be unblocked, e.g, readers behind a canceled writer
+			rw.notifyWaitersLocked()
+		}
+		rw.mu.Unlock()
+	}
+	return
+}
+
+func (rw *FRWMutex) onWaitedLocked(w bool, waited time.Duration) {
+	if w {
+		rw.stats.WriteWaits++
+		rw.stats.WriteWaitTotal += waited
+		if waited > rw.stats.MaxWriteWait {
+			rw.stats.MaxWriteWait = waited
+		}
+	} else {
+		rw.stats.ReadWaits++
+		rw.stats.ReadWaitTotal += waited
+		if waited > rw.stats.MaxReadWait {
+			rw.stats.MaxReadWait = waited
+		}
+	}
+}
+
+func (rw *FRWMutex) notifyWaitersLocked() {
+	if rw.writer {
+		return
+	}
+
+	if rw.upgrader != nil {
+		// the upgrader is one of the readers
+		if rw.readers == 1 {
+			rw.readers = 0
+			rw.writer = true
+			close(rw.upgrader.ready)
+			rw.upgrader = nil
+		}
+		return
+	}
+
+	for {
+		next := rw.waiters.Front()
+		if next == nil {
+			return
+		}
+
+		waiter := next.Value.(*frwWaiter)
+		if waiter.w {
+			if rw.readers > 0 {
+				return
+			}
+			rw.writer = true
+			rw.waiters.Remove(next)
+			close(waiter.ready)
+			return
+		}
+
+		rw.readers++
+		rw.waiters.Remove(next)
+		close(waiter.ready)
+	}
+}
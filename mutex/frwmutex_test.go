@@ -0,0 +1,196 @@
+package mutex
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFRWMutex(t *testing.T) {
+	rw := NewFRWMutex(false)
+	ctx := context.Background()
+
+	if rw.RLock(ctx) != nil || !rw.TryRLock() || rw.TryLock() {
+		t.FailNow()
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+
+	if rw.Lock(ctx) != nil || rw.TryRLock() {
+		t.FailNow()
+	}
+	rw.Unlock()
+
+	// a waiting writer blocks arriving readers
+	rw.RLock(ctx)
+	lockedCh := make(chan struct{})
+	go func() {
+		rw.Lock(ctx)
+		close(lockedCh)
+	}()
+	time.Sleep(time.Millisecond * 100)
+	if rw.TryRLock() {
+		t.FailNow()
+	}
+
+	// canceled reader doesn't affect the queue
+	cctx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+	defer cancel()
+	if rw.RLock(cctx) != context.DeadlineExceeded {
+		t.FailNow()
+	}
+
+	rw.RUnlock()
+	<-lockedCh
+
+	// downgrade lets the waiting readers in, but not the writer behind them
+	rLockedCh := make(chan struct{})
+	go func() {
+		rw.RLock(ctx)
+		close(rLockedCh)
+	}()
+	time.Sleep(time.Millisecond * 100)
+	go func() {
+		rw.Lock(ctx)
+		rw.Unlock()
+	}()
+	time.Sleep(time.Millisecond * 100)
+	rw.Downgrade()
+	<-rLockedCh
+	rw.RUnlock()
+	rw.RUnlock()
+
+	stats := rw.Stats()
+	if stats.ReadWaits != 1 || stats.WriteWaits < 1 || stats.Canceled != 1 || stats.MaxWriteWait < time.Millisecond*100 {
+		t.Fatal(stats)
+	}
+}
+
+func TestFRWMutexCancel(t *testing.T) {
+	rw := NewFRWMutex(false)
+	ctx := context.Background()
+	rw.RLock(ctx)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, time.Millisecond*300)
+		defer cancel()
+		if rw.Lock(ctx) == nil {
+			t.Error("Lock should be canceled")
+		}
+	}()
+	time.Sleep(time.Millisecond * 100)
+
+	// queued after the writer, granted once it's canceled
+	doneCh := make(chan struct{})
+	go func() {
+		rw.RLock(ctx)
+		rw.RUnlock()
+		close(doneCh)
+	}()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+	rw.RUnlock()
+}
+
+func TestFRWMutexOrder(t *testing.T) {
+	for _, preferWriter := range []bool{false, true} {
+		rw := NewFRWMutex(preferWriter)
+		ctx := context.Background()
+		rw.Lock(ctx)
+
+		var (
+			mu    sync.Mutex
+			order []string
+			wg    sync.WaitGroup
+		)
+		record := func(s string) {
+			mu.Lock()
+			order = append(order, s)
+			mu.Unlock()
+		}
+		for _, w := range []bool{false, true} {
+			wg.Add(1)
+			go func(w bool) {
+				defer wg.Done()
+				if w {
+					rw.Lock(ctx)
+					record("w")
+					rw.Unlock()
+				} else {
+					rw.RLock(ctx)
+					record("r")
+					rw.RUnlock()
+				}
+			}(w)
+			time.Sleep(time.Millisecond * 50)
+		}
+		rw.Unlock()
+		wg.Wait()
+
+		expected := "r"
+		if preferWriter {
+			expected = "w"
+		}
+		if order[0] != expected {
+			t.Fatal(preferWriter, order)
+		}
+	}
+}
+
+func TestFRWMutexUpgrade(t *testing.T) {
+	rw := NewFRWMutex(false)
+	ctx := context.Background()
+
+	// the only reader upgrades immediately
+	rw.RLock(ctx)
+	if rw.TryUpgrade(ctx) != nil || rw.TryRLock() {
+		t.FailNow()
+	}
+	rw.Unlock()
+
+	rw.RLock(ctx)
+	rw.RLock(ctx)
+	upgradedCh := make(chan struct{})
+	go func() {
+		if rw.TryUpgrade(ctx) != nil {
+			t.Error("TryUpgrade should succeed")
+		}
+		close(upgradedCh)
+	}()
+	time.Sleep(time.Millisecond * 100)
+
+	// the upgrade goes ahead of waiting writers, and conflicts with other upgrades
+	writerDoneCh := make(chan struct{})
+	go func() {
+		rw.Lock(ctx)
+		rw.Unlock()
+		close(writerDoneCh)
+	}()
+	if rw.TryUpgrade(ctx) != ErrUpgradeConflict {
+		t.FailNow()
+	}
+	rw.RUnlock()
+	<-upgradedCh
+	rw.Unlock()
+	<-writerDoneCh
+
+	// canceled upgrade keeps the read lock
+	rw.RLock(ctx)
+	rw.RLock(ctx)
+	cctx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+	defer cancel()
+	if rw.TryUpgrade(cctx) != context.DeadlineExceeded {
+		t.FailNow()
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+	if !rw.TryLock() {
+		t.FailNow()
+	}
+	rw.Unlock()
+}
@@ -0,0 +1,182 @@
+package mutex
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// KeyedMutex implements per key cancelable rwmutex,
+// the lock of a key is created on demand and freed once no one holds or waits for it,
+// so keys never share a lock like they do with concurrent.Bucket.
+type KeyedMutex[K comparable] struct {
+	mu    sync.Mutex
+	less  func(a, b K) bool
+	locks map[K]*keyedLock
+}
+
+type keyedLock struct {
+	rw   CRWMutex
+	refs int // holders and waiters
+}
+
+// NewKeyedMutex is ctor for KeyedMutex
+// less defines the canonical order in which LockAll/RLockAll acquire keys.
+func NewKeyedMutex[K comparable](less func(a, b K) bool) *KeyedMutex[K] {
+	return &KeyedMutex[K]{less: less, locks: make(map[K]*keyedLock)}
+}
+
+// Lock key with context
+func (km *KeyedMutex[K]) Lock(ctx context.Context, key K) (err error) {
+	l := km.ref(key)
+	err = l.rw.Lock(ctx)
+	if err != nil {
+		km.unref(key)
+	}
+	return
+}
+
+// Unlock should only be called after a successful Lock of key
+func (km *KeyedMutex[K]) Unlock(key K) {
+	km.get(key).rw.Unlock()
+	km.unref(key)
+}
+
+// RLock key with context
+func (km *KeyedMutex[K]) RLock(ctx context.Context, key K) (err error) {
+	l := km.ref(key)
+	err = l.rw.RLock(ctx)
+	if err != nil {
+		km.unref(key)
+	}
+	return
+}
+
+// RUnlock should only be called after a successful RLock of key
+func (km *KeyedMutex[K]) RUnlock(key K) {
+	km.get(key).rw.RUnlock()
+	km.unref(key)
+}
+
+// TryLock returns true if lock of key acquired
+func (km *KeyedMutex[K]) TryLock(key K) bool {
+	if !km.ref(key).rw.TryLock() {
+		km.unref(key)
+		return false
+	}
+	return true
+}
+
+// TryRLock returns true if rlock of key acquired
+func (km *KeyedMutex[K]) TryRLock(key K) bool {
+	if !km.ref(key).rw.TryRLock() {
+		km.unref(key)
+		return false
+	}
+	return true
+}
+
+// LockAll locks all keys in canonical order so that it never deadlocks with
+// another LockAll/RLockAll, duplicated keys are locked once.
+// Either all or none of the keys are locked when it returns.
+func (km *KeyedMutex[K]) LockAll(ctx context.Context, keys ...K) (err error) {
+	err = km.all(ctx, keys, km.Lock, km.Unlock)
+	return
+}
+
+// UnlockAll should only be called after a successful LockAll of the same keys
+func (km *KeyedMutex[K]) UnlockAll(keys ...K) {
+	for _, key := range km.canonical(keys) {
+		km.Unlock(key)
+	}
+}
+
+// RLockAll is like LockAll but for rlock
+func (km *KeyedMutex[K]) RLockAll(ctx context.Context, keys ...K) (err error) {
+	err = km.all(ctx, keys, km.RLock, km.RUnlock)
+	return
+}
+
+// RUnlockAll should only be called after a successful RLockAll of the same keys
+func (km *KeyedMutex[K]) RUnlockAll(keys ...K) {
+	for _, key := range km.canonical(keys) {
+		km.RUnlock(key)
+	}
+}
+
+// Len returns the number of keys held or waited for
+func (km *KeyedMutex[K]) Len() int {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	return len(km.locks)
+}
+
+func (km *KeyedMutex[K]) all(ctx context.Context, keys []K, lock func(context.Context, K) error, unlock func(K)) (err error) {
+	keys = km.canonical(keys)
+	for i, key := range keys {
+		err = lock(ctx, key)
+		if err != nil {
+			for j := i - 1; j >= 0; j-- {
+				unlock(keys[j])
+			}
+			return
+		}
+	}
+	return
+}
+
+// canonical returns sorted keys without duplication, keys is not modified
+func (km *KeyedMutex[K]) canonical(keys []K) []K {
+	sorted := make([]K, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return km.less(sorted[i], sorted[j])
+	})
+
+	n := 0
+	for i, key := range sorted {
+		if i > 0 && key == sorted[n-1] {
+			continue
+		}
+		sorted[n] = key
+		n++
+	}
+	return sorted[:n]
+}
+
+func (km *KeyedMutex[K]) ref(key K) *keyedLock {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	l := km.locks[key]
+	if l == nil {
+		l = &keyedLock{}
+		l.rw.Init()
+		km.locks[key] = l
+	}
+	l.refs++
+	return l
+}
+
+func (km *KeyedMutex[K]) get(key K) *keyedLock {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	l := km.locks[key]
+	if l == nil {
+		panic("unlock of unlocked key")
+	}
+	return l
+}
+
+func (km *KeyedMutex[K]) unref(key K) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	l := km.locks[key]
+	l.refs--
+	if l.refs == 0 {
+		delete(km.locks, key)
+	}
+}
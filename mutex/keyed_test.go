@@ -0,0 +1,106 @@
+package mutex
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	km := NewKeyedMutex(func(a, b string) bool { return a < b })
+	ctx := context.Background()
+
+	// different keys don't block each other
+	if km.Lock(ctx, "a") != nil || !km.TryLock("b") || km.TryLock("a") || km.TryRLock("a") {
+		t.FailNow()
+	}
+	if km.Len() != 2 {
+		t.FailNow()
+	}
+	km.Unlock("a")
+	km.Unlock("b")
+
+	// idle entries are freed, including after canceled waits
+	if km.Len() != 0 {
+		t.FailNow()
+	}
+	km.RLock(ctx, "a")
+	if !km.TryRLock("a") {
+		t.FailNow()
+	}
+	cctx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+	defer cancel()
+	if km.Lock(cctx, "a") != context.DeadlineExceeded {
+		t.FailNow()
+	}
+	km.RUnlock("a")
+	km.RUnlock("a")
+	if km.Len() != 0 {
+		t.FailNow()
+	}
+
+	// LockAll is all or none
+	km.Lock(ctx, "c")
+	cctx, cancel = context.WithTimeout(ctx, time.Millisecond*100)
+	defer cancel()
+	if km.LockAll(cctx, "d", "c", "a") != context.DeadlineExceeded || !km.TryLock("a") || !km.TryLock("d") {
+		t.FailNow()
+	}
+	km.UnlockAll("a", "c", "d")
+	if km.Len() != 0 {
+		t.FailNow()
+	}
+}
+
+func TestKeyedMutexLockAll(t *testing.T) {
+	km := NewKeyedMutex(func(a, b int) bool { return a < b })
+	ctx := context.Background()
+
+	// opposite orders and duplicated keys never deadlock
+	var (
+		wg      sync.WaitGroup
+		counter int
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			km.LockAll(ctx, 1, 2, 3, 1)
+			counter++
+			km.UnlockAll(1, 2, 3, 1)
+		}()
+		go func() {
+			defer wg.Done()
+			km.LockAll(ctx, 3, 2, 1)
+			counter++
+			km.UnlockAll(3, 2, 1)
+		}()
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second * 5):
+		t.FailNow()
+	}
+
+	if counter != 100 || km.Len() != 0 {
+		t.FailNow()
+	}
+
+	km.RLockAll(ctx, 1, 2)
+	km.RLockAll(ctx, 2, 1)
+	if km.TryLock(1) {
+		t.FailNow()
+	}
+	km.RUnlockAll(1, 2)
+	km.RUnlockAll(2, 1)
+	if km.Len() != 0 {
+		t.FailNow()
+	}
+}
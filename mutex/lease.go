@@ -0,0 +1,299 @@
+package mutex
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrLeaseHeld is returned by LeaseStore.Acquire when the lease is held by others
+	ErrLeaseHeld = errors.New("lease held by others")
+	// ErrLeaseLost is returned when the lease is no longer held by the caller
+	ErrLeaseLost = errors.New("lease lost")
+	// ErrStaleToken is returned by Fence.Check for a token older than the latest seen
+	ErrStaleToken = errors.New("stale fencing token")
+)
+
+// LeaseStore persists leases, the fencing token of a lease name increases on every grant
+type LeaseStore interface {
+	// Acquire grants the lease to owner if it's free or expired, returns ErrLeaseHeld otherwise
+	Acquire(ctx context.Context, name, owner string, ttl time.Duration) (token uint64, err error)
+	// Renew extends the lease by ttl, returns ErrLeaseLost if owner no longer holds token
+	Renew(ctx context.Context, name, owner string, token uint64, ttl time.Duration) error
+	// Release frees the lease, returns ErrLeaseLost if owner no longer holds token
+	Release(ctx context.Context, name, owner string, token uint64) error
+}
+
+// LeaseConf for LeaseMutex
+type LeaseConf struct {
+	Name  string
+	Owner string // unique among all holders, e.g, host and pid
+	TTL   time.Duration
+	// below are optional
+	RenewInterval time.Duration // default TTL/3
+	RetryInterval time.Duration // for Lock, default TTL/10
+	// called in the renewal goroutine when the lease is lost before Unlock
+	OnExpired func(token uint64)
+}
+
+// LeaseMutex is a lease based lock over a LeaseStore,
+// the lease is renewed in background until Unlock.
+// The holder may lose the lease anytime, so the fencing token should be
+// checked by the protected resource, e.g, with a Fence.
+type LeaseMutex struct {
+	store LeaseStore
+	conf  LeaseConf
+
+	mu       sync.Mutex
+	held     bool
+	token    uint64
+	deadline time.Time // when the lease expires if not renewed
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// NewLeaseMutex is ctor for LeaseMutex
+func NewLeaseMutex(store LeaseStore, conf LeaseConf) *LeaseMutex {
+	if conf.TTL <= 0 {
+		panic("TTL <= 0")
+	}
+	if conf.RenewInterval <= 0 {
+		conf.RenewInterval = conf.TTL / 3
+	}
+	if conf.RetryInterval <= 0 {
+		conf.RetryInterval = conf.TTL / 10
+	}
+	return &LeaseMutex{store: store, conf: conf}
+}
+
+// Lock retries until the lease is acquired or ctx is done
+func (m *LeaseMutex) Lock(ctx context.Context) (token uint64, err error) {
+	for {
+		token, err = m.TryLock(ctx)
+		if err != ErrLeaseHeld {
+			return
+		}
+
+		timer := time.NewTimer(m.conf.RetryInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err = ctx.Err()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// TryLock returns ErrLeaseHeld if the lease is held by others
+func (m *LeaseMutex) TryLock(ctx context.Context) (token uint64, err error) {
+	m.mu.Lock()
+	if m.held || m.stopCh != nil {
+		m.mu.Unlock()
+		panic("TryLock of locked LeaseMutex")
+	}
+	m.mu.Unlock()
+
+	start := time.Now()
+	token, err = m.store.Acquire(ctx, m.conf.Name, m.conf.Owner, m.conf.TTL)
+	if err != nil {
+		return
+	}
+
+	m.mu.Lock()
+	m.held = true
+	m.token = token
+	m.deadline = start.Add(m.conf.TTL)
+	m.stopCh = make(chan struct{})
+	m.doneCh = make(chan struct{})
+	go m.renew(token, m.stopCh, m.doneCh)
+	m.mu.Unlock()
+	return
+}
+
+// Unlock stops renewal and releases the lease,
+// returns ErrLeaseLost if the lease has already been lost.
+func (m *LeaseMutex) Unlock(ctx context.Context) (err error) {
+	m.mu.Lock()
+	if m.stopCh == nil {
+		m.mu.Unlock()
+		panic("Unlock of unlocked LeaseMutex")
+	}
+	stopCh, doneCh := m.stopCh, m.doneCh
+	m.stopCh, m.doneCh = nil, nil
+	m.mu.Unlock()
+
+	close(stopCh)
+	<-doneCh
+
+	m.mu.Lock()
+	held, token := m.held, m.token
+	m.held = false
+	m.mu.Unlock()
+
+	if !held {
+		err = ErrLeaseLost
+		return
+	}
+	err = m.store.Release(ctx, m.conf.Name, m.conf.Owner, token)
+	return
+}
+
+// Token returns the fencing token if the lease is still held
+func (m *LeaseMutex) Token() (token uint64, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.held || time.Now().After(m.deadline) {
+		return
+	}
+	return m.token, true
+}
+
+func (m *LeaseMutex) renew(token uint64, stopCh, doneCh chan struct{}) {
+	defer close(doneCh)
+
+	ticker := time.NewTicker(m.conf.RenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.Lock()
+		deadline := m.deadline
+		m.mu.Unlock()
+
+		start := time.Now()
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		err := m.store.Renew(ctx, m.conf.Name, m.conf.Owner, token, m.conf.TTL)
+		cancel()
+
+		if err == nil {
+			m.mu.Lock()
+			m.deadline = start.Add(m.conf.TTL)
+			m.mu.Unlock()
+			continue
+		}
+
+		// transient errors are retried until the lease expires
+		if err == ErrLeaseLost || !time.Now().Before(deadline) {
+			m.mu.Lock()
+			m.held = false
+			m.mu.Unlock()
+
+			if m.conf.OnExpired != nil {
+				m.conf.OnExpired(token)
+			}
+			return
+		}
+	}
+}
+
+// Fence rejects stale lease holders, the protected resource checks
+// the fencing token of every request with it.
+type Fence struct {
+	mu     sync.Mutex
+	latest uint64
+}
+
+// Check returns ErrStaleToken if a newer token has been seen
+func (f *Fence) Check(token uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if token < f.latest {
+		return ErrStaleToken
+	}
+	f.latest = token
+	return nil
+}
+
+// MemLeaseStore is a LeaseStore for goroutines in the same process
+type MemLeaseStore struct {
+	mu     sync.Mutex
+	leases map[string]*leaseRecord
+}
+
+type leaseRecord struct {
+	Owner  string `json:"owner"`
+	Token  uint64 `json:"token"`
+	Expiry int64  `json:"expiry"` // unix nano, 0 if released
+}
+
+func (r *leaseRecord) heldBy(owner string, token uint64, now int64) bool {
+	return r.Owner == owner && r.Token == token && now < r.Expiry
+}
+
+func (r *leaseRecord) acquire(owner string, ttl time.Duration, now int64) (token uint64, err error) {
+	if now < r.Expiry {
+		err = ErrLeaseHeld
+		return
+	}
+	r.Owner = owner
+	r.Token++
+	r.Expiry = now + int64(ttl)
+	token = r.Token
+	return
+}
+
+func (r *leaseRecord) renew(owner string, token uint64, ttl time.Duration, now int64) error {
+	if !r.heldBy(owner, token, now) {
+		return ErrLeaseLost
+	}
+	r.Expiry = now + int64(ttl)
+	return nil
+}
+
+func (r *leaseRecord) release(owner string, token uint64, now int64) error {
+	if !r.heldBy(owner, token, now) {
+		return ErrLeaseLost
+	}
+	r.Owner = ""
+	r.Expiry = 0
+	return nil
+}
+
+// NewMemLeaseStore is ctor for MemLeaseStore
+func NewMemLeaseStore() *MemLeaseStore {
+	return &MemLeaseStore{leases: make(map[string]*leaseRecord)}
+}
+
+func (s *MemLeaseStore) record(name string) *leaseRecord {
+	r := s.leases[name]
+	if r == nil {
+		r = &leaseRecord{}
+		s.leases[name] = r
+	}
+	return r
+}
+
+// Acquire implements LeaseStore
+func (s *MemLeaseStore) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (token uint64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err = s.record(name).acquire(owner, ttl, time.Now().UnixNano())
+	return
+}
+
+// Renew implements LeaseStore
+func (s *MemLeaseStore) Renew(ctx context.Context, name, owner string, token uint64, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.record(name).renew(owner, token, ttl, time.Now().UnixNano())
+}
+
+// Release implements LeaseStore
+func (s *MemLeaseStore) Release(ctx context.Context, name, owner string, token uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.record(name).release(owner, token, time.Now().UnixNano())
+}
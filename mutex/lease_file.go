@@ -0,0 +1,137 @@
+//go:build !windows
+// +build !windows
+
+package mutex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrInvalidLeaseName when a lease name can't be used as a file name under the directory
+var ErrInvalidLeaseName = errors.New("invalid lease name")
+
+// FileLeaseStore is a LeaseStore for processes on the same host,
+// each lease is a file under a directory, updated with flock held.
+type FileLeaseStore struct {
+	dir string
+}
+
+// NewFileLeaseStore is ctor for FileLeaseStore, dir is created if not exists
+func NewFileLeaseStore(dir string) (s *FileLeaseStore, err error) {
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return
+	}
+	s = &FileLeaseStore{dir: dir}
+	return
+}
+
+// Acquire implements LeaseStore
+func (s *FileLeaseStore) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (token uint64, err error) {
+	err = s.update(ctx, name, func(r *leaseRecord, now int64) (err error) {
+		token, err = r.acquire(owner, ttl, now)
+		return
+	})
+	return
+}
+
+// Renew implements LeaseStore
+func (s *FileLeaseStore) Renew(ctx context.Context, name, owner string, token uint64, ttl time.Duration) error {
+	return s.update(ctx, name, func(r *leaseRecord, now int64) error {
+		return r.renew(owner, token, ttl, now)
+	})
+}
+
+// Release implements LeaseStore
+func (s *FileLeaseStore) Release(ctx context.Context, name, owner string, token uint64) error {
+	return s.update(ctx, name, func(r *leaseRecord, now int64) error {
+		return r.release(owner, token, now)
+	})
+}
+
+// update reads the record of name, applies f and writes it back if f succeeds, all with flock held.
+// the flock is on a separate file, since the record is replaced by rename so that it's never partly written.
+func (s *FileLeaseStore) update(ctx context.Context, name string, f func(r *leaseRecord, now int64) error) (err error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		err = ErrInvalidLeaseName
+		return
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	path := filepath.Join(s.dir, name+".lease")
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer lock.Close()
+
+	err = unix.Flock(int(lock.Fd()), unix.LOCK_EX)
+	if err != nil {
+		return
+	}
+	defer unix.Flock(int(lock.Fd()), unix.LOCK_UN)
+
+	var r leaseRecord
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		err = nil
+	case err != nil:
+		return
+	default:
+		err = json.Unmarshal(data, &r)
+		if err != nil {
+			return
+		}
+	}
+
+	err = f(&r, time.Now().UnixNano())
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(&r)
+	if err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return
+	}
+
+	// make the rename durable
+	d, err := os.Open(s.dir)
+	if err != nil {
+		return
+	}
+	err = d.Sync()
+	d.Close()
+	return
+}
@@ -0,0 +1,129 @@
+package mutex
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testLeaseMutex(t *testing.T, store LeaseStore) {
+	ctx := context.Background()
+	conf := LeaseConf{Name: "test", TTL: time.Millisecond * 300}
+
+	conf.Owner = "a"
+	a := NewLeaseMutex(store, conf)
+	conf.Owner = "b"
+	b := NewLeaseMutex(store, conf)
+
+	tokenA, err := a.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.TryLock(ctx); err != ErrLeaseHeld {
+		t.Fatal(err)
+	}
+
+	// renewed beyond TTL
+	time.Sleep(conf.TTL * 2)
+	if token, ok := a.Token(); !ok || token != tokenA {
+		t.FailNow()
+	}
+
+	// b gets a newer token once released
+	lockedCh := make(chan uint64)
+	go func() {
+		token, err := b.Lock(ctx)
+		if err != nil {
+			t.Error(err)
+		}
+		lockedCh <- token
+	}()
+	time.Sleep(time.Millisecond * 100)
+	if err = a.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	tokenB := <-lockedCh
+	if tokenB <= tokenA {
+		t.FailNow()
+	}
+
+	var fence Fence
+	if fence.Check(tokenB) != nil || fence.Check(tokenA) != ErrStaleToken {
+		t.FailNow()
+	}
+	if err = b.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemLeaseStore(t *testing.T) {
+	testLeaseMutex(t, NewMemLeaseStore())
+}
+
+func TestFileLeaseStore(t *testing.T) {
+	store, err := NewFileLeaseStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testLeaseMutex(t, store)
+
+	for _, name := range []string{"", "../x", "a/b", ".hidden"} {
+		_, err = store.Acquire(context.Background(), name, "a", time.Second)
+		if err != ErrInvalidLeaseName {
+			t.Fatal(name, err)
+		}
+	}
+}
+
+// failingStore fails Renew when failing is set
+type failingStore struct {
+	LeaseStore
+	failing int32
+}
+
+func (s *failingStore) Renew(ctx context.Context, name, owner string, token uint64, ttl time.Duration) error {
+	if atomic.LoadInt32(&s.failing) != 0 {
+		return context.DeadlineExceeded
+	}
+	return s.LeaseStore.Renew(ctx, name, owner, token, ttl)
+}
+
+func TestLeaseExpired(t *testing.T) {
+	ctx := context.Background()
+	store := &failingStore{LeaseStore: NewMemLeaseStore()}
+	expiredCh := make(chan uint64, 1)
+	m := NewLeaseMutex(store, LeaseConf{Name: "test", Owner: "a", TTL: time.Millisecond * 300, OnExpired: func(token uint64) {
+		expiredCh <- token
+	}})
+
+	token, err := m.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&store.failing, 1)
+
+	select {
+	case expired := <-expiredCh:
+		if expired != token {
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+	if _, ok := m.Token(); ok {
+		t.FailNow()
+	}
+
+	// others can acquire the expired lease
+	other := NewLeaseMutex(store, LeaseConf{Name: "test", Owner: "b", TTL: time.Millisecond * 300})
+	if _, err = other.TryLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if m.Unlock(ctx) != ErrLeaseLost {
+		t.FailNow()
+	}
+	if other.Unlock(ctx) != nil {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,205 @@
+package osc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// JobType is the direction of a schema change job
+type JobType byte
+
+const (
+	// JobAdd goes from StateAbsent to StatePublic
+	JobAdd JobType = iota
+	// JobDelete goes from StatePublic to StateAbsent
+	JobDelete
+)
+
+// JobStatus of a schema change job
+type JobStatus byte
+
+const (
+	// JobRunning means the job is going forward
+	JobRunning JobStatus = iota
+	// JobRollingBack means the job is canceled and going backward
+	JobRollingBack
+	// JobDone means the job reached its target state
+	JobDone
+	// JobRolledBack means the job is back to its initial state
+	JobRolledBack
+	// JobFailed means the job exceeded max retries, it can still be canceled
+	JobFailed
+)
+
+// Job is a schema change job persisted by JobStore
+type Job struct {
+	ID   string
+	Type JobType
+	// for JobHandler to know what to change
+	Args   []byte
+	State  SchemaState
+	Status JobStatus
+	// progress of the reorg phase of State
+	Checkpoint []byte
+	ReorgDone  bool
+	// last error and the number of consecutive retries
+	Error   string
+	Retries int
+}
+
+// Finished returns whether the job will make no more progress unless canceled
+func (j *Job) Finished() bool {
+	return j.Status == JobDone || j.Status == JobRolledBack || j.Status == JobFailed
+}
+
+// String implements fmt.Stringer interface.
+func (s JobStatus) String() string {
+	switch s {
+	case JobRunning:
+		return "running"
+	case JobRollingBack:
+		return "rolling back"
+	case JobDone:
+		return "done"
+	case JobRolledBack:
+		return "rolled back"
+	case JobFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("invalid status %d", s)
+	}
+}
+
+// JobStore persists jobs
+type JobStore interface {
+	// Save creates or overwrites the job, it should be durable when returns
+	Save(job Job) error
+	List() ([]Job, error)
+}
+
+// ErrInvalidJobID when the job id can't be a file name
+var ErrInvalidJobID = errors.New("invalid job id")
+
+// FileJobStore is a JobStore that keeps each job as a json file under a directory
+type FileJobStore struct {
+	dir string
+}
+
+const jobFileSuffix = ".job"
+
+// NewFileJobStore is ctor for FileJobStore, dir is created if not exists
+func NewFileJobStore(dir string) (s *FileJobStore, err error) {
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return
+	}
+	s = &FileJobStore{dir: dir}
+	return
+}
+
+// Save implements JobStore, the file is replaced atomically by renaming
+func (s *FileJobStore) Save(job Job) (err error) {
+	if job.ID == "" || strings.ContainsAny(job.ID, `/\`) || strings.HasPrefix(job.ID, ".") {
+		err = ErrInvalidJobID
+		return
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return
+	}
+
+	path := filepath.Join(s.dir, job.ID+jobFileSuffix)
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return
+	}
+
+	// make the rename durable
+	d, err := os.Open(s.dir)
+	if err != nil {
+		return
+	}
+	err = d.Sync()
+	d.Close()
+	return
+}
+
+// List implements JobStore
+func (s *FileJobStore) List() (jobs []Job, err error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*"+jobFileSuffix))
+	if err != nil {
+		return
+	}
+
+	for _, path := range paths {
+		var data []byte
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return
+		}
+		var job Job
+		err = json.Unmarshal(data, &job)
+		if err != nil {
+			err = fmt.Errorf("%s: %v", path, err)
+			return
+		}
+		jobs = append(jobs, job)
+	}
+	return
+}
+
+// MemJobStore is a JobStore in memory, for tests
+type MemJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+// NewMemJobStore is ctor for MemJobStore
+func NewMemJobStore() *MemJobStore {
+	return &MemJobStore{jobs: make(map[string]Job)}
+}
+
+// Save implements JobStore
+func (s *MemJobStore) Save(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.Args = append([]byte(nil), job.Args...)
+	job.Checkpoint = append([]byte(nil), job.Checkpoint...)
+	s.jobs[job.ID] = job
+	return nil
+}
+
+// List implements JobStore
+func (s *MemJobStore) List() (jobs []Job, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	return
+}
@@ -0,0 +1,398 @@
+package osc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zhiqiangxu/util"
+)
+
+// JobHandler carries out schema changes of jobs for Runner
+type JobHandler interface {
+	// Enter makes job enter state, it should block until the state has been synced.
+	// It's retried after errors and restarts, so it must be idempotent,
+	// and a canceled job may enter a state of the opposite direction.
+	Enter(ctx context.Context, job Job, state SchemaState) error
+	// Reorg reorganizes a batch of data for job.State, which is StateWriteReorganization or StateDeleteReorganization,
+	// starting from checkpoint, nil for the first batch, and returns the checkpoint of the next batch.
+	// A batch may be run again after restart if its checkpoint is not saved.
+	Reorg(ctx context.Context, job Job, checkpoint []byte) (next []byte, done bool, err error)
+}
+
+// RunnerConf for Runner
+type RunnerConf struct {
+	// directory of the default FileJobStore
+	Dir string
+	// overrides Dir if not nil
+	Store   JobStore
+	Handler JobHandler
+	// wait between retries, default 1s
+	RetryInterval time.Duration
+	// consecutive failures before a job fails, 0 means unlimited
+	MaxRetries int
+}
+
+// Runner drives schema change jobs step by step, persisting each state and reorg checkpoint,
+// so that unfinished jobs resume after restart.
+type Runner struct {
+	conf   RunnerConf
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	runs map[string]*jobRun
+}
+
+type jobRun struct {
+	job        Job // saved state, only updated by the running goroutine
+	rollback   bool
+	stepCancel context.CancelFunc
+	done       chan struct{} // closed when finished
+}
+
+var (
+	// ErrJobExists when submitting a job with an existing id
+	ErrJobExists = errors.New("job exists")
+	// ErrJobNotFound when the job id is unknown
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobFinished when canceling a job that's done or rolled back
+	ErrJobFinished = errors.New("job finished")
+	// ErrRunnerClosed when the runner is closed
+	ErrRunnerClosed = errors.New("runner closed")
+)
+
+// NewRunner is ctor for Runner, unfinished jobs in the store are resumed
+func NewRunner(conf RunnerConf) (r *Runner, err error) {
+	if conf.Handler == nil {
+		err = errors.New("nil Handler")
+		return
+	}
+	if conf.Store == nil {
+		conf.Store, err = NewFileJobStore(conf.Dir)
+		if err != nil {
+			return
+		}
+	}
+	if conf.RetryInterval <= 0 {
+		conf.RetryInterval = time.Second
+	}
+
+	jobs, err := conf.Store.List()
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r = &Runner{conf: conf, ctx: ctx, cancel: cancel, runs: make(map[string]*jobRun)}
+	r.mu.Lock()
+	for _, job := range jobs {
+		jr := &jobRun{job: job, done: make(chan struct{})}
+		r.runs[job.ID] = jr
+		if job.Finished() {
+			close(jr.done)
+		} else {
+			r.start(jr)
+		}
+	}
+	r.mu.Unlock()
+	return
+}
+
+// Submit a job, only ID, Type and Args are used
+func (r *Runner) Submit(job Job) (err error) {
+	job = Job{ID: job.ID, Type: job.Type, Args: job.Args, Status: JobRunning}
+	if job.Type == JobDelete {
+		job.State = StatePublic
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ctx.Err() != nil {
+		err = ErrRunnerClosed
+		return
+	}
+	if r.runs[job.ID] != nil {
+		err = ErrJobExists
+		return
+	}
+	err = r.conf.Store.Save(job)
+	if err != nil {
+		return
+	}
+
+	jr := &jobRun{job: job, done: make(chan struct{})}
+	r.runs[job.ID] = jr
+	r.start(jr)
+	return
+}
+
+// Cancel rolls back the job to its initial state, the step in progress is interrupted
+func (r *Runner) Cancel(id string) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	jr := r.runs[id]
+	if jr == nil {
+		err = ErrJobNotFound
+		return
+	}
+
+	switch jr.job.Status {
+	case JobDone, JobRolledBack:
+		err = ErrJobFinished
+	case JobFailed:
+		// not running, restart it
+		job := jr.job
+		job.Status, job.Retries, job.Error = JobRollingBack, 0, ""
+		err = r.conf.Store.Save(job)
+		if err != nil {
+			return
+		}
+		jr.job = job
+		jr.done = make(chan struct{})
+		r.start(jr)
+	case JobRunning:
+		jr.rollback = true
+		if jr.stepCancel != nil {
+			jr.stepCancel()
+		}
+	}
+	return
+}
+
+// Job returns the saved job
+func (r *Runner) Job(id string) (job Job, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	jr := r.runs[id]
+	if jr == nil {
+		err = ErrJobNotFound
+		return
+	}
+	job = jr.job
+	return
+}
+
+// Wait until the job is finished
+func (r *Runner) Wait(ctx context.Context, id string) (job Job, err error) {
+	r.mu.Lock()
+	jr := r.runs[id]
+	var done chan struct{}
+	if jr != nil {
+		done = jr.done
+	}
+	r.mu.Unlock()
+
+	if jr == nil {
+		err = ErrJobNotFound
+		return
+	}
+
+	select {
+	case <-done:
+		job, err = r.Job(id)
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-r.ctx.Done():
+		err = ErrRunnerClosed
+	}
+	return
+}
+
+// Close stops all jobs where they are, they resume with a new Runner on the same store
+func (r *Runner) Close() {
+	r.mu.Lock()
+	r.cancel()
+	r.mu.Unlock()
+
+	r.wg.Wait()
+}
+
+func (r *Runner) start(jr *jobRun) {
+	util.GoFunc(&r.wg, func() {
+		r.run(jr)
+	})
+}
+
+func (r *Runner) run(jr *jobRun) {
+	for {
+		r.mu.Lock()
+		if r.ctx.Err() != nil {
+			r.mu.Unlock()
+			return
+		}
+		job := jr.job
+		if jr.rollback {
+			jr.rollback = false
+			job.Status, job.Retries, job.Error = JobRollingBack, 0, ""
+		}
+		stepCtx, cancel := context.WithCancel(r.ctx)
+		jr.stepCancel = cancel
+		r.mu.Unlock()
+
+		status := job.Status
+		var (
+			finished bool
+			err      error
+		)
+		if !r.exhausted(job) {
+			finished, err = r.step(stepCtx, &job)
+		}
+		if (err != nil && stepCtx.Err() == nil) || r.exhausted(job) {
+			// exhausted without error if the failure wasn't saved, only save it again
+			if err != nil {
+				job.Retries++
+				job.Error = err.Error()
+			}
+			if r.exhausted(job) {
+				job.Status = JobFailed
+				finished = true
+			}
+			if r.conf.Store.Save(job) != nil {
+				// keep counting retries, so that MaxRetries applies even if the store keeps failing
+				job.Status = status
+				finished = false
+			}
+			r.commit(jr, job)
+			if !finished {
+				select {
+				case <-time.After(r.conf.RetryInterval):
+				case <-stepCtx.Done():
+				}
+			}
+		} else if err == nil {
+			r.commit(jr, job)
+		}
+		cancel()
+
+		r.mu.Lock()
+		jr.stepCancel = nil
+		if finished && jr.job.Finished() {
+			close(jr.done)
+			r.mu.Unlock()
+			return
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *Runner) exhausted(job Job) bool {
+	return r.conf.MaxRetries > 0 && job.Retries > r.conf.MaxRetries
+}
+
+func (r *Runner) commit(jr *jobRun, job Job) {
+	r.mu.Lock()
+	jr.job = job
+	r.mu.Unlock()
+}
+
+// step makes one move for job and saves it, a job moves towards StatePublic if
+// it's an add job running or a delete job rolling back, otherwise towards StateAbsent.
+func (r *Runner) step(ctx context.Context, job *Job) (finished bool, err error) {
+	add := (job.Type == JobAdd) == (job.Status == JobRunning)
+	target, reorgState := StateAbsent, StateDeleteReorganization
+	if add {
+		target, reorgState = StatePublic, StateWriteReorganization
+	}
+
+	next := *job
+	switch {
+	case job.State == target:
+		if job.Status == JobRunning {
+			next.Status = JobDone
+		} else {
+			next.Status = JobRolledBack
+		}
+		next.Retries, next.Error = 0, ""
+		finished = true
+	case job.State == reorgState && !job.ReorgDone:
+		next.Checkpoint, next.ReorgDone, err = r.conf.Handler.Reorg(ctx, *job, job.Checkpoint)
+		if err != nil {
+			return
+		}
+		next.Retries, next.Error = 0, ""
+	default:
+		var state SchemaState
+		state, err = nextState(job.State, add)
+		if err != nil {
+			return
+		}
+		err = r.conf.Handler.Enter(ctx, *job, state)
+		if err != nil {
+			return
+		}
+		next.State, next.Checkpoint, next.ReorgDone = state, nil, false
+		next.Retries, next.Error = 0, ""
+	}
+
+	err = r.conf.Store.Save(next)
+	if err != nil {
+		finished = false
+		return
+	}
+	*job = next
+	return
+}
+
+// nextState follows StepAdd or StepDelete,
+// the reorg state of the opposite direction is left as if its reorg never started
+func nextState(state SchemaState, add bool) (next SchemaState, err error) {
+	rec := &stateRecorder{state: state}
+	if add {
+		if state == StateDeleteReorganization {
+			rec.state = StateDeleteOnly
+		}
+		err = StepAdd(rec)
+	} else {
+		if state == StateWriteReorganization {
+			rec.state = StateWriteOnly
+		}
+		err = StepDelete(rec)
+	}
+	next = rec.state
+	return
+}
+
+// stateRecorder implements AddSchemaChange and DeleteSchemaChange by recording the state entered
+type stateRecorder struct {
+	state SchemaState
+}
+
+func (s *stateRecorder) GetState() SchemaState {
+	return s.state
+}
+
+func (s *stateRecorder) EnterDeleteOnly() error {
+	s.state = StateDeleteOnly
+	return nil
+}
+
+func (s *stateRecorder) EnterWriteOnly() error {
+	s.state = StateWriteOnly
+	return nil
+}
+
+func (s *stateRecorder) EnterReorgAfterWriteOnly() error {
+	s.state = StateWriteReorganization
+	return nil
+}
+
+func (s *stateRecorder) EnterReorgAfterDeleteOnly() error {
+	s.state = StateDeleteReorganization
+	return nil
+}
+
+func (s *stateRecorder) EnterPublic() error {
+	s.state = StatePublic
+	return nil
+}
+
+func (s *stateRecorder) EnterAbsent() error {
+	s.state = StateAbsent
+	return nil
+}
@@ -0,0 +1,297 @@
+package osc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHandler reorganizes rows in batches of 10, the checkpoint is the next row
+type testHandler struct {
+	mu      sync.Mutex
+	rows    int
+	states  []SchemaState
+	batches []int // starting row of each batch
+	// Reorg blocks at this row until canceled if >= 0
+	blockAt  int
+	blocked  chan struct{}
+	failWith error
+	enters   int
+}
+
+func newTestHandler(rows int) *testHandler {
+	return &testHandler{rows: rows, blockAt: -1, blocked: make(chan struct{}, 1)}
+}
+
+func (h *testHandler) Enter(ctx context.Context, job Job, state SchemaState) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.enters++
+	if h.failWith != nil {
+		return h.failWith
+	}
+	h.states = append(h.states, state)
+	return nil
+}
+
+func (h *testHandler) Reorg(ctx context.Context, job Job, checkpoint []byte) (next []byte, done bool, err error) {
+	from := 0
+	if checkpoint != nil {
+		from, err = strconv.Atoi(string(checkpoint))
+		if err != nil {
+			return
+		}
+	}
+
+	h.mu.Lock()
+	blockAt := h.blockAt
+	h.mu.Unlock()
+	if from == blockAt {
+		h.blocked <- struct{}{}
+		<-ctx.Done()
+		err = ctx.Err()
+		return
+	}
+
+	h.mu.Lock()
+	h.batches = append(h.batches, from)
+	h.mu.Unlock()
+
+	to := from + 10
+	if to >= h.rows {
+		done = true
+		return
+	}
+	next = []byte(strconv.Itoa(to))
+	return
+}
+
+func (h *testHandler) snapshot() (states []SchemaState, batches []int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	states = append(states, h.states...)
+	batches = append(batches, h.batches...)
+	return
+}
+
+func waitJob(t *testing.T, r *Runner, id string) Job {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	job, err := r.Wait(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func equalStates(a, b []SchemaState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRunnerResume(t *testing.T) {
+	dir := t.TempDir()
+	h := newTestHandler(100)
+	h.blockAt = 30
+
+	r, err := NewRunner(RunnerConf{Dir: dir, Handler: h})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Submit(Job{ID: "add_index", Type: JobAdd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Submit(Job{ID: "add_index"}) != ErrJobExists {
+		t.FailNow()
+	}
+
+	// restart in the middle of reorg
+	<-h.blocked
+	r.Close()
+	job, err := r.Job("add_index")
+	if err != nil || job.State != StateWriteReorganization || string(job.Checkpoint) != "30" {
+		t.Fatal(job, err)
+	}
+
+	h.mu.Lock()
+	h.blockAt = -1
+	h.mu.Unlock()
+	r, err = NewRunner(RunnerConf{Dir: dir, Handler: h})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	job = waitJob(t, r, "add_index")
+	if job.Status != JobDone || job.State != StatePublic {
+		t.Fatal(job)
+	}
+	states, batches := h.snapshot()
+	if !equalStates(states, []SchemaState{StateDeleteOnly, StateWriteOnly, StateWriteReorganization, StatePublic}) {
+		t.Fatal(states)
+	}
+	// each batch once, resumed from the checkpoint
+	for i, from := range batches {
+		if from != i*10 {
+			t.Fatal(batches)
+		}
+	}
+	if len(batches) != 10 {
+		t.Fatal(batches)
+	}
+}
+
+func TestRunnerCancel(t *testing.T) {
+	h := newTestHandler(50)
+	h.blockAt = 20
+
+	r, err := NewRunner(RunnerConf{Store: NewMemJobStore(), Handler: h})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	err = r.Submit(Job{ID: "add_column", Type: JobAdd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-h.blocked
+	h.mu.Lock()
+	h.blockAt = -1
+	h.mu.Unlock()
+	err = r.Cancel("add_column")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := waitJob(t, r, "add_column")
+	if job.Status != JobRolledBack || job.State != StateAbsent {
+		t.Fatal(job)
+	}
+	states, _ := h.snapshot()
+	expected := []SchemaState{StateDeleteOnly, StateWriteOnly, StateWriteReorganization,
+		StateDeleteOnly, StateDeleteReorganization, StateAbsent}
+	if !equalStates(states, expected) {
+		t.Fatal(states)
+	}
+	if r.Cancel("add_column") != ErrJobFinished {
+		t.FailNow()
+	}
+
+	// a delete job goes the other way
+	err = r.Submit(Job{ID: "drop_column", Type: JobDelete})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = waitJob(t, r, "drop_column")
+	if job.Status != JobDone || job.State != StateAbsent {
+		t.Fatal(job)
+	}
+}
+
+func TestRunnerFail(t *testing.T) {
+	h := newTestHandler(10)
+	h.failWith = errors.New("sync timeout")
+
+	r, err := NewRunner(RunnerConf{Store: NewMemJobStore(), Handler: h, RetryInterval: time.Millisecond, MaxRetries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	err = r.Submit(Job{ID: "add_index", Type: JobAdd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := waitJob(t, r, "add_index")
+	if job.Status != JobFailed || job.Retries != 4 || job.Error != "sync timeout" {
+		t.Fatal(job)
+	}
+
+	// roll back the failed job
+	h.mu.Lock()
+	h.failWith = nil
+	h.mu.Unlock()
+	err = r.Cancel("add_index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = waitJob(t, r, "add_index")
+	if job.Status != JobRolledBack || job.State != StateAbsent {
+		t.Fatal(job)
+	}
+}
+
+// failingStore fails Save while fail is set
+type failingStore struct {
+	JobStore
+	mu   sync.Mutex
+	fail bool
+}
+
+func (s *failingStore) setFail(fail bool) {
+	s.mu.Lock()
+	s.fail = fail
+	s.mu.Unlock()
+}
+
+func (s *failingStore) Save(job Job) error {
+	s.mu.Lock()
+	fail := s.fail
+	s.mu.Unlock()
+	if fail {
+		return errors.New("disk full")
+	}
+	return s.JobStore.Save(job)
+}
+
+func TestRunnerFailUnsaved(t *testing.T) {
+	h := newTestHandler(10)
+	h.failWith = errors.New("sync timeout")
+	store := &failingStore{JobStore: NewMemJobStore()}
+
+	r, err := NewRunner(RunnerConf{Store: store, Handler: h, RetryInterval: time.Millisecond, MaxRetries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	err = r.Submit(Job{ID: "add_index", Type: JobAdd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.setFail(true)
+
+	// retries still run out while saving fails
+	time.Sleep(100 * time.Millisecond)
+	h.mu.Lock()
+	enters := h.enters
+	h.mu.Unlock()
+	if enters > 4 {
+		t.Fatal(enters)
+	}
+
+	store.setFail(false)
+	job := waitJob(t, r, "add_index")
+	if job.Status != JobFailed || job.Retries != 4 {
+		t.Fatal(job)
+	}
+	jobs, err := store.List()
+	if err != nil || len(jobs) != 1 || jobs[0].Status != JobFailed {
+		t.Fatal(jobs, err)
+	}
+}
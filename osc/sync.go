@@ -0,0 +1,315 @@
+package osc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zhiqiangxu/util"
+)
+
+// Coordinator keeps the latest schema version and the versions loaded by live nodes,
+// a node is live while its lease, renewed by Ack, is not expired.
+// Live nodes are at most one version behind the latest.
+type Coordinator interface {
+	// Ack reports the version loaded by node and renews its lease for ttl, node is registered if not live.
+	// It fails with ErrStaleVersion if version is more than one behind the latest.
+	Ack(ctx context.Context, node string, version int64, ttl time.Duration) error
+	// Leave removes node
+	Leave(ctx context.Context, node string) error
+	// Latest returns the latest version
+	Latest(ctx context.Context) (int64, error)
+	// Publish makes version the latest, it must be latest+1 and all live nodes must have loaded the latest
+	Publish(ctx context.Context, version int64) error
+	// Synced returns whether all live nodes have loaded version
+	Synced(ctx context.Context, version int64) (bool, error)
+}
+
+var (
+	// ErrStaleVersion when a node acks a version more than one behind the latest
+	ErrStaleVersion = errors.New("stale schema version")
+	// ErrNotSynced when publishing before all live nodes have loaded the latest
+	ErrNotSynced = errors.New("schema version not synced")
+)
+
+// MemCoordinator is a Coordinator in memory
+type MemCoordinator struct {
+	mu     sync.Mutex
+	latest int64
+	nodes  map[string]memNode
+}
+
+type memNode struct {
+	version int64
+	expiry  time.Time
+}
+
+// NewMemCoordinator is ctor for MemCoordinator
+func NewMemCoordinator() *MemCoordinator {
+	return &MemCoordinator{nodes: make(map[string]memNode)}
+}
+
+// Ack implements Coordinator
+func (c *MemCoordinator) Ack(ctx context.Context, node string, version int64, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version > c.latest {
+		return fmt.Errorf("version %d not published, latest %d", version, c.latest)
+	}
+	if version < c.latest-1 {
+		return ErrStaleVersion
+	}
+	if n, ok := c.nodes[node]; ok && version < n.version {
+		return fmt.Errorf("version %d older than acked %d", version, n.version)
+	}
+	c.nodes[node] = memNode{version: version, expiry: time.Now().Add(ttl)}
+	return nil
+}
+
+// Leave implements Coordinator
+func (c *MemCoordinator) Leave(ctx context.Context, node string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.nodes, node)
+	return nil
+}
+
+// Latest implements Coordinator
+func (c *MemCoordinator) Latest(ctx context.Context) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.latest, nil
+}
+
+// Publish implements Coordinator
+func (c *MemCoordinator) Publish(ctx context.Context, version int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version != c.latest+1 {
+		return fmt.Errorf("publish version %d, latest %d", version, c.latest)
+	}
+	if !c.syncedLocked(c.latest) {
+		return ErrNotSynced
+	}
+	c.latest = version
+	return nil
+}
+
+// Synced implements Coordinator
+func (c *MemCoordinator) Synced(ctx context.Context, version int64) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.syncedLocked(version), nil
+}
+
+func (c *MemCoordinator) syncedLocked(version int64) bool {
+	now := time.Now()
+	for name, n := range c.nodes {
+		if now.After(n.expiry) {
+			delete(c.nodes, name)
+			continue
+		}
+		if n.version < version {
+			return false
+		}
+	}
+	return true
+}
+
+// NodeConf for Node
+type NodeConf struct {
+	Name string
+	// lease of the node
+	TTL time.Duration
+	// interval of checking the latest version and renewing the lease, default TTL/3
+	Interval time.Duration
+	// Load makes version take effect on the node
+	Load func(ctx context.Context, version int64) error
+}
+
+// Node follows the latest schema version of a Coordinator,
+// it's not Healthy to serve once its lease may have expired.
+type Node struct {
+	c       Coordinator
+	conf    NodeConf
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	version int64
+	loaded  bool
+	acked   time.Time
+}
+
+// NewNode is ctor for Node, it loads the latest version and acks in background
+func NewNode(c Coordinator, conf NodeConf) *Node {
+	if conf.Interval <= 0 {
+		conf.Interval = conf.TTL / 3
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Node{c: c, conf: conf, ctx: ctx, cancel: cancel}
+	util.GoFunc(&n.wg, n.loop)
+	return n
+}
+
+// Version returns the loaded version, ok is false if nothing loaded yet
+func (n *Node) Version() (version int64, ok bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.version, n.loaded
+}
+
+// Healthy returns whether the lease is surely alive in the Coordinator
+func (n *Node) Healthy() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.loaded && time.Since(n.acked) < n.conf.TTL
+}
+
+// Close stops the node and leaves
+func (n *Node) Close() {
+	n.cancel()
+	n.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.conf.TTL)
+	n.c.Leave(ctx, n.conf.Name)
+	cancel()
+}
+
+func (n *Node) loop() {
+	ticker := time.NewTicker(n.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		n.sync()
+
+		select {
+		case <-ticker.C:
+		case <-n.ctx.Done():
+			return
+		}
+	}
+}
+
+// sync loads the latest version if necessary, then acks
+func (n *Node) sync() {
+	latest, err := n.c.Latest(n.ctx)
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	version, loaded := n.version, n.loaded
+	n.mu.Unlock()
+
+	if !loaded || version < latest {
+		err = n.conf.Load(n.ctx, latest)
+		if err != nil {
+			return
+		}
+		version = latest
+		n.mu.Lock()
+		n.version, n.loaded = version, true
+		n.mu.Unlock()
+	}
+
+	// the lease in Coordinator expires no earlier than start+TTL
+	start := time.Now()
+	err = n.c.Ack(n.ctx, n.conf.Name, version, n.conf.TTL)
+	if err != nil {
+		return
+	}
+	n.mu.Lock()
+	n.acked = start
+	n.mu.Unlock()
+}
+
+// Syncer steps the schema version of a Coordinator, each step takes effect on all live nodes before the next
+type Syncer struct {
+	c        Coordinator
+	interval time.Duration
+}
+
+// NewSyncer is ctor for Syncer, interval is for polling Coordinator
+func NewSyncer(c Coordinator, interval time.Duration) *Syncer {
+	return &Syncer{c: c, interval: interval}
+}
+
+// WaitSynced waits until all live nodes have loaded the latest version
+func (s *Syncer) WaitSynced(ctx context.Context) (version int64, err error) {
+	for {
+		version, err = s.c.Latest(ctx)
+		if err != nil {
+			return
+		}
+		var synced bool
+		synced, err = s.c.Synced(ctx, version)
+		if err != nil || synced {
+			return
+		}
+
+		select {
+		case <-time.After(s.interval):
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+// Step publishes a new version after all live nodes have loaded the latest, and waits until they load it
+func (s *Syncer) Step(ctx context.Context) (version int64, err error) {
+	for {
+		version, err = s.WaitSynced(ctx)
+		if err != nil {
+			return
+		}
+		err = s.c.Publish(ctx, version+1)
+		if err == ErrNotSynced {
+			// a node joined behind
+			continue
+		}
+		if err != nil {
+			return
+		}
+		break
+	}
+
+	version, err = s.WaitSynced(ctx)
+	return
+}
+
+// Handler wraps h so that each state entered by a job is a new schema version synced to all live nodes,
+// h.Enter should persist the state where nodes load it.
+func (s *Syncer) Handler(h JobHandler) JobHandler {
+	return &syncedHandler{JobHandler: h, s: s}
+}
+
+type syncedHandler struct {
+	JobHandler
+	s *Syncer
+}
+
+func (h *syncedHandler) Enter(ctx context.Context, job Job, state SchemaState) (err error) {
+	// a previous step may be interrupted before synced
+	_, err = h.s.WaitSynced(ctx)
+	if err != nil {
+		return
+	}
+	err = h.JobHandler.Enter(ctx, job, state)
+	if err != nil {
+		return
+	}
+	_, err = h.s.Step(ctx)
+	return
+}
@@ -0,0 +1,117 @@
+package osc
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyncerCluster(t *testing.T) {
+	c := NewMemCoordinator()
+
+	// the last node hangs loading version 2, its lease expires
+	const total = 5
+	nodes := make([]*Node, total)
+	for i := range nodes {
+		i := i
+		nodes[i] = NewNode(c, NodeConf{
+			Name:     fmt.Sprint("node", i),
+			TTL:      100 * time.Millisecond,
+			Interval: 10 * time.Millisecond,
+			Load: func(ctx context.Context, version int64) error {
+				if i == total-1 && version == 2 {
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+				return nil
+			},
+		})
+	}
+	defer func() {
+		for _, n := range nodes {
+			n.Close()
+		}
+	}()
+
+	// healthy nodes are at most one version behind the latest
+	var (
+		violation string
+		wg        sync.WaitGroup
+	)
+	stopCh := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+
+			latest, _ := c.Latest(context.Background())
+			for _, n := range nodes {
+				version, _ := n.Version()
+				if n.Healthy() && version < latest-1 && violation == "" {
+					violation = fmt.Sprintf("node at %d, latest %d", version, latest)
+				}
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	for _, n := range nodes {
+		for !n.Healthy() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	h := newTestHandler(30)
+	r, err := NewRunner(RunnerConf{Store: NewMemJobStore(), Handler: NewSyncer(c, 5*time.Millisecond).Handler(h)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	err = r.Submit(Job{ID: "add_index", Type: JobAdd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := waitJob(t, r, "add_index")
+	if job.Status != JobDone {
+		t.Fatal(job)
+	}
+
+	close(stopCh)
+	wg.Wait()
+	if violation != "" {
+		t.Fatal(violation)
+	}
+
+	// a version for each state
+	latest, _ := c.Latest(context.Background())
+	if latest != 4 {
+		t.Fatal(latest)
+	}
+	for i, n := range nodes {
+		version, _ := n.Version()
+		if i == total-1 {
+			if n.Healthy() || version != 1 {
+				t.Fatal("hung node", version)
+			}
+			continue
+		}
+		if version != latest {
+			t.Fatal(i, version)
+		}
+	}
+
+	// a node can't come back with a stale version
+	if c.Ack(context.Background(), "node4", 1, time.Second) != ErrStaleVersion {
+		t.FailNow()
+	}
+}
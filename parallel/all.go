@@ -0,0 +1,156 @@
+package parallel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+// ErrSkipped is the Chunk.Err of a chunk not run because of cancellation or StopOnFailure
+var ErrSkipped = errors.New("chunk skipped")
+
+// AllConf for AllWithResult
+type AllConf struct {
+	Total   int
+	Unit    int
+	Workers int
+	// attempts per chunk, a chunk fails permanently if all attempts fail
+	Retry    int
+	Cooldown time.Duration
+	// stop dispatching on the first permanent failure
+	StopOnFailure bool
+	// called after each chunk is done, never concurrently
+	OnProgress func(Progress)
+}
+
+// Chunk is the outcome of [From, To)
+type Chunk[R any] struct {
+	From, To int
+	R        R
+	Err      error // the last error if failed permanently
+}
+
+// Progress of AllWithResult, in number of items
+type Progress struct {
+	Completed  int
+	Failed     int
+	Total      int
+	Elapsed    time.Duration
+	Throughput float64 // completed items per second, Elapsed is taken as at least 1ms
+}
+
+// AllWithResult is like All, but returns the result or error of every chunk,
+// a permanently failed chunk is not retried by other workers.
+// err is ctx.Err() if canceled, or the first permanent failure if StopOnFailure,
+// chunks not run are marked with ErrSkipped.
+func AllWithResult[R any](ctx context.Context, conf AllConf, handleFunc func(ctx context.Context, workerIdx int, from int, to int) (R, error)) (chunks []Chunk[R], err error) {
+	if conf.Workers <= 0 {
+		panic("workers <= 0")
+	}
+	if conf.Unit <= 0 {
+		panic("unit <= 0")
+	}
+	if conf.Retry <= 0 {
+		conf.Retry = 1
+	}
+
+	for from := 0; from < conf.Total; from += conf.Unit {
+		to := from + conf.Unit
+		if to > conf.Total {
+			to = conf.Total
+		}
+		chunks = append(chunks, Chunk[R]{From: from, To: to, Err: ErrSkipped})
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		progress = Progress{Total: conf.Total}
+		start    = time.Now()
+		firstErr error
+		taskChan = make(chan int)
+	)
+	onDone := func(chunk *Chunk[R]) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if chunk.Err == nil {
+			progress.Completed += chunk.To - chunk.From
+		} else {
+			progress.Failed += chunk.To - chunk.From
+			if firstErr == nil && conf.StopOnFailure {
+				firstErr = chunk.Err
+				cancel()
+			}
+		}
+		if conf.OnProgress != nil {
+			progress.Elapsed = time.Since(start)
+			// clamped so that it stays finite right after start
+			elapsed := progress.Elapsed
+			if elapsed < time.Millisecond {
+				elapsed = time.Millisecond
+			}
+			progress.Throughput = float64(progress.Completed) / elapsed.Seconds()
+			conf.OnProgress(progress)
+		}
+	}
+
+	wg.Add(conf.Workers)
+	for i := 0; i < conf.Workers; i++ {
+		go func(i int) {
+			defer wg.Done()
+
+			for idx := range taskChan {
+				chunk := &chunks[idx]
+				for k := 0; k < conf.Retry; k++ {
+					chunk.R, chunk.Err = handleFunc(runCtx, i, chunk.From, chunk.To)
+					if chunk.Err == nil || k == conf.Retry-1 {
+						break
+					}
+					zlog.Warn().Int("i", i).Err(chunk.Err).Msg("handleFunc")
+					if !sleepContext(runCtx, conf.Cooldown) {
+						break
+					}
+				}
+				onDone(chunk)
+			}
+		}(i)
+	}
+
+dispatch:
+	for idx := range chunks {
+		select {
+		case taskChan <- idx:
+		case <-runCtx.Done():
+			break dispatch
+		}
+	}
+	close(taskChan)
+	wg.Wait()
+
+	if firstErr != nil {
+		err = firstErr
+	} else {
+		err = ctx.Err()
+	}
+	return
+}
+
+// sleepContext returns false if ctx is done before d elapses
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
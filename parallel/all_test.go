@@ -0,0 +1,64 @@
+package parallel
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAllWithResult(t *testing.T) {
+	errFlaky := errors.New("flaky")
+
+	// chunk [10, 20) fails once, retried by the same worker
+	var (
+		failed    int32
+		progress  Progress
+		callbacks int
+	)
+	chunks, err := AllWithResult(context.Background(), AllConf{Total: 95, Unit: 10, Workers: 3, Retry: 2, OnProgress: func(p Progress) {
+		progress = p
+		callbacks++
+	}}, func(ctx context.Context, workerIdx, from, to int) (int, error) {
+		if from == 10 && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+			return 0, errFlaky
+		}
+		return to - from, nil
+	})
+	if err != nil || len(chunks) != 10 || callbacks != 10 {
+		t.Fatal(err, len(chunks), callbacks)
+	}
+	sum := 0
+	for _, chunk := range chunks {
+		if chunk.Err != nil {
+			t.Fatal(chunk)
+		}
+		sum += chunk.R
+	}
+	if sum != 95 || progress.Completed != 95 || progress.Total != 95 || progress.Throughput <= 0 {
+		t.Fatal(sum, progress)
+	}
+
+	// permanent failure stops dispatching
+	chunks, err = AllWithResult(context.Background(), AllConf{Total: 100, Unit: 1, Workers: 1, Retry: 3, StopOnFailure: true}, func(ctx context.Context, workerIdx, from, to int) (int, error) {
+		if from == 5 {
+			return 0, errFlaky
+		}
+		return 0, nil
+	})
+	if err != errFlaky || chunks[5].Err != errFlaky || chunks[4].Err != nil || chunks[99].Err != ErrSkipped {
+		t.Fatal(err)
+	}
+
+	// cancellation interrupts the cooldown
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	_, err = AllWithResult(ctx, AllConf{Total: 1, Unit: 1, Workers: 1, Retry: 3, Cooldown: time.Hour}, func(ctx context.Context, workerIdx, from, to int) (int, error) {
+		return 0, errFlaky
+	})
+	if err != context.DeadlineExceeded || time.Since(start) > time.Second {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,223 @@
+package parallel
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HedgeConf for Hedged
+type HedgeConf struct {
+	// max attempts including the first one
+	Attempts int
+	// delay before launching the next attempt, a failed attempt launches the next immediately
+	Delay time.Duration
+	// if not nil, the delay is the Percentile latency of successful attempts
+	// once Latency has enough samples, and successful attempts are recorded in it
+	Latency    *LatencyTracker
+	Percentile float64
+}
+
+// Hedged starts one attempt, and launches another each time the delay elapses
+// without success, until Attempts are launched.
+// The first success wins and the other attempts are canceled,
+// err is the last error if all attempts fail.
+func Hedged[R any](ctx context.Context, conf HedgeConf, f func(ctx context.Context, attempt int) (R, error)) (r R, attempt int, err error) {
+	if conf.Attempts <= 0 {
+		conf.Attempts = 1
+	}
+	delay := conf.Delay
+	if conf.Latency != nil {
+		if d, ok := conf.Latency.Percentile(conf.Percentile); ok {
+			delay = d
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type reply struct {
+		r       R
+		attempt int
+		err     error
+	}
+	replyCh := make(chan reply, conf.Attempts)
+	launch := func(attempt int) {
+		go func() {
+			start := time.Now()
+			r, err := f(ctx, attempt)
+			if err == nil && conf.Latency != nil {
+				conf.Latency.Record(time.Since(start))
+			}
+			replyCh <- reply{r: r, attempt: attempt, err: err}
+		}()
+	}
+
+	launched, failed := 1, 0
+	launch(0)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case rep := <-replyCh:
+			if rep.err == nil {
+				r, attempt = rep.r, rep.attempt
+				return
+			}
+			failed++
+			err = rep.err
+			if failed == conf.Attempts {
+				return
+			}
+			if failed == launched {
+				launch(launched)
+				launched++
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if launched < conf.Attempts {
+				launch(launched)
+				launched++
+				timer.Reset(delay)
+			}
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+// LatencyTracker keeps the latest latencies for percentile estimation
+type LatencyTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+	min     int
+}
+
+// NewLatencyTracker is ctor for LatencyTracker
+// window is the number of latest samples kept,
+// Percentile is not available until minSamples are recorded
+func NewLatencyTracker(window, minSamples int) *LatencyTracker {
+	if window <= 0 {
+		panic("window <= 0")
+	}
+	return &LatencyTracker{samples: make([]time.Duration, window), min: minSamples}
+}
+
+// Record a latency
+func (t *LatencyTracker) Record(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.samples[t.next] = d
+	t.next++
+	if t.next == len(t.samples) {
+		t.next = 0
+		t.full = true
+	}
+}
+
+// Percentile returns the p (0~1) percentile latency, ok is false without enough samples
+func (t *LatencyTracker) Percentile(p float64) (d time.Duration, ok bool) {
+	t.mu.Lock()
+	n := t.next
+	if t.full {
+		n = len(t.samples)
+	}
+	if n == 0 || n < t.min {
+		t.mu.Unlock()
+		return
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, t.samples[:n])
+	t.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	idx := int(p * float64(n))
+	if idx >= n {
+		idx = n - 1
+	}
+	if idx < 0 {
+		idx = 0
+	}
+	d, ok = sorted[idx], true
+	return
+}
+
+// Reply is the result of worker I
+type Reply[R any] struct {
+	I int
+	R R
+}
+
+// QuorumError is returned by Quorum when k successes become impossible
+type QuorumError struct {
+	Succeeded int
+	Errs      []error // of failed workers, in order of failure
+}
+
+func (e *QuorumError) Error() string {
+	return fmt.Sprintf("quorum failed with %d successes, %d failures, last error: %v", e.Succeeded, len(e.Errs), e.Errs[len(e.Errs)-1])
+}
+
+// Quorum runs n workers concurrently and returns once k of them succeed,
+// or fails with *QuorumError once k successes are impossible.
+// The remaining workers are canceled either way.
+func Quorum[R any](ctx context.Context, n, k int, f func(ctx context.Context, i int) (R, error)) (replies []Reply[R], err error) {
+	if k <= 0 || k > n {
+		err = fmt.Errorf("invalid quorum %d of %d", k, n)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type reply struct {
+		Reply[R]
+		err error
+	}
+	replyCh := make(chan reply, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			r, err := f(ctx, i)
+			replyCh <- reply{Reply: Reply[R]{I: i, R: r}, err: err}
+		}(i)
+	}
+
+	var errs []error
+	for {
+		select {
+		case rep := <-replyCh:
+			if rep.err == nil {
+				replies = append(replies, rep.Reply)
+				if len(replies) == k {
+					return
+				}
+				continue
+			}
+			errs = append(errs, rep.err)
+			if n-len(errs) < k {
+				err = &QuorumError{Succeeded: len(replies), Errs: errs}
+				replies = nil
+				return
+			}
+		case <-ctx.Done():
+			err = ctx.Err()
+			replies = nil
+			return
+		}
+	}
+}
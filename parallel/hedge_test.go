@@ -0,0 +1,100 @@
+package parallel
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedged(t *testing.T) {
+	// the first attempt is slow, the hedged one wins and the first is canceled
+	var canceled int32
+	start := time.Now()
+	r, attempt, err := Hedged(context.Background(), HedgeConf{Attempts: 3, Delay: time.Millisecond * 50}, func(ctx context.Context, attempt int) (int, error) {
+		if attempt == 0 {
+			<-ctx.Done()
+			atomic.AddInt32(&canceled, 1)
+			return 0, ctx.Err()
+		}
+		return attempt * 10, nil
+	})
+	if err != nil || attempt != 1 || r != 10 || time.Since(start) > time.Millisecond*500 {
+		t.Fatal(r, attempt, err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	if atomic.LoadInt32(&canceled) != 1 {
+		t.FailNow()
+	}
+
+	// a fast success launches no extra attempt
+	var launched int32
+	_, _, err = Hedged(context.Background(), HedgeConf{Attempts: 3, Delay: time.Second}, func(ctx context.Context, attempt int) (int, error) {
+		atomic.AddInt32(&launched, 1)
+		return 0, nil
+	})
+	if err != nil || atomic.LoadInt32(&launched) != 1 {
+		t.FailNow()
+	}
+
+	// failures launch the next attempt immediately
+	errFail := errors.New("fail")
+	start = time.Now()
+	_, _, err = Hedged(context.Background(), HedgeConf{Attempts: 3, Delay: time.Hour}, func(ctx context.Context, attempt int) (int, error) {
+		return 0, errFail
+	})
+	if err != errFail || time.Since(start) > time.Second {
+		t.FailNow()
+	}
+
+	// delay from latency percentile
+	tracker := NewLatencyTracker(100, 10)
+	if _, ok := tracker.Percentile(0.9); ok {
+		t.FailNow()
+	}
+	for i := 1; i <= 100; i++ {
+		tracker.Record(time.Duration(i) * time.Millisecond)
+	}
+	if d, ok := tracker.Percentile(0.9); !ok || d != time.Millisecond*91 {
+		t.Fatal(d)
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	errFail := errors.New("fail")
+
+	replies, err := Quorum(context.Background(), 5, 3, func(ctx context.Context, i int) (int, error) {
+		if i < 2 {
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+		return i, nil
+	})
+	if err != nil || len(replies) != 3 {
+		t.Fatal(replies, err)
+	}
+	for _, rep := range replies {
+		if rep.I < 2 || rep.R != rep.I {
+			t.Fatal(replies)
+		}
+	}
+
+	// fails as soon as 3 of 5 is impossible, without waiting for the slow ones
+	start := time.Now()
+	_, err = Quorum(context.Background(), 5, 3, func(ctx context.Context, i int) (int, error) {
+		if i < 3 {
+			return 0, errFail
+		}
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	qerr, ok := err.(*QuorumError)
+	if !ok || len(qerr.Errs) != 3 || qerr.Succeeded != 0 || time.Since(start) > time.Second {
+		t.Fatal(err)
+	}
+
+	if _, err = Quorum(context.Background(), 2, 3, func(ctx context.Context, i int) (int, error) { return 0, nil }); err == nil {
+		t.FailNow()
+	}
+}
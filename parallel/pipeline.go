@@ -0,0 +1,455 @@
+package parallel
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pipeline runs stages connected by bounded channels, so a slow stage
+// slows down its upstream instead of piling up items.
+// The first error of any stage cancels the context shared by all stages.
+//
+//	p := NewPipeline(ctx)
+//	s := SourceChan(p, "read", ch)
+//	m := Map(s, "parse", 4, true, parse)
+//	Sink(Batch(m, "batch", 100, time.Second), "write", write)
+//	err := p.Wait()
+type Pipeline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	parent context.Context
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	err     error
+	stages  []*StageStats
+	outputs []*stageOutput
+}
+
+// Stage is the output of a pipeline stage
+type Stage[T any] struct {
+	p   *Pipeline
+	ch  <-chan T
+	out *stageOutput
+}
+
+// stageOutput is drained by Wait if not consumed by a downstream stage
+type stageOutput struct {
+	consumed bool
+	drain    func()
+}
+
+// StageStats is the metrics of a stage
+type StageStats struct {
+	Name    string
+	In      int64 // items received
+	Out     int64 // items emitted
+	Errors  int64
+	BusyNS  int64 // time spent in user functions, in nanoseconds
+	Workers int
+}
+
+// NewPipeline is ctor for Pipeline
+func NewPipeline(ctx context.Context) *Pipeline {
+	p := &Pipeline{parent: ctx}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	return p
+}
+
+// Context is canceled on the first error
+func (p *Pipeline) Context() context.Context {
+	return p.ctx
+}
+
+// Wait blocks until all stages return,
+// returns the first error of stages, or the error of parent context.
+// The output of a stage without downstream stages is discarded.
+func (p *Pipeline) Wait() (err error) {
+	p.mu.Lock()
+	for _, out := range p.outputs {
+		if !out.consumed {
+			out.consumed = true
+			p.wg.Add(1)
+			go func(drain func()) {
+				defer p.wg.Done()
+				drain()
+			}(out.drain)
+		}
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	p.cancel()
+
+	p.mu.Lock()
+	err = p.err
+	p.mu.Unlock()
+	if err == nil {
+		err = p.parent.Err()
+	}
+	return
+}
+
+// Stats returns the metrics of all stages in order of creation
+func (p *Pipeline) Stats() (stats []StageStats) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, s := range p.stages {
+		stats = append(stats, StageStats{
+			Name:    s.Name,
+			In:      atomic.LoadInt64(&s.In),
+			Out:     atomic.LoadInt64(&s.Out),
+			Errors:  atomic.LoadInt64(&s.Errors),
+			BusyNS:  atomic.LoadInt64(&s.BusyNS),
+			Workers: s.Workers,
+		})
+	}
+	return
+}
+
+func (p *Pipeline) newStage(name string, workers int) *StageStats {
+	s := &StageStats{Name: name, Workers: workers}
+
+	p.mu.Lock()
+	p.stages = append(p.stages, s)
+	p.mu.Unlock()
+	return s
+}
+
+func (p *Pipeline) fail(s *StageStats, err error) {
+	atomic.AddInt64(&s.Errors, 1)
+
+	p.mu.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.mu.Unlock()
+
+	p.cancel()
+}
+
+func (s *StageStats) busy(start time.Time) {
+	atomic.AddInt64(&s.BusyNS, int64(time.Since(start)))
+}
+
+func newOutput[T any](p *Pipeline, ch <-chan T) *Stage[T] {
+	out := &stageOutput{drain: func() { drain(ch) }}
+
+	p.mu.Lock()
+	p.outputs = append(p.outputs, out)
+	p.mu.Unlock()
+	return &Stage[T]{p: p, ch: ch, out: out}
+}
+
+// consume marks the stage as consumed by a downstream stage
+func (s *Stage[T]) consume() {
+	s.p.mu.Lock()
+	s.out.consumed = true
+	s.p.mu.Unlock()
+}
+
+// send returns false if ctx is done before v is sent
+func send[T any](ctx context.Context, ch chan<- T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Source emits items by gen until it returns, emit returns false once the pipeline is canceled
+func Source[T any](p *Pipeline, name string, gen func(ctx context.Context, emit func(T) bool) error) *Stage[T] {
+	stats := p.newStage(name, 1)
+	out := make(chan T)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(out)
+
+		start := time.Now()
+		err := gen(p.ctx, func(v T) bool {
+			if !send(p.ctx, out, v) {
+				return false
+			}
+			atomic.AddInt64(&stats.Out, 1)
+			return true
+		})
+		stats.busy(start)
+		if err != nil {
+			p.fail(stats, err)
+		}
+	}()
+
+	return newOutput[T](p, out)
+}
+
+// SourceChan emits items from ch until it's closed, e.g, diskqueue.StreamRead
+func SourceChan[T any](p *Pipeline, name string, ch <-chan T) *Stage[T] {
+	return Source(p, name, func(ctx context.Context, emit func(T) bool) error {
+		for {
+			select {
+			case v, ok := <-ch:
+				if !ok {
+					return nil
+				}
+				if !emit(v) {
+					return nil
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+}
+
+// Map applies f with workers goroutines, output is in input order if ordered
+func Map[T, U any](in *Stage[T], name string, workers int, ordered bool, f func(ctx context.Context, v T) (U, error)) *Stage[U] {
+	if workers <= 0 {
+		panic("workers <= 0")
+	}
+
+	in.consume()
+	p := in.p
+	stats := p.newStage(name, workers)
+	out := make(chan U, workers)
+
+	apply := func(v T) (u U, ok bool) {
+		atomic.AddInt64(&stats.In, 1)
+		start := time.Now()
+		u, err := f(p.ctx, v)
+		stats.busy(start)
+		if err != nil {
+			p.fail(stats, err)
+			return
+		}
+		ok = true
+		return
+	}
+
+	if !ordered {
+		var wg sync.WaitGroup
+		wg.Add(workers)
+		for i := 0; i < workers; i++ {
+			p.wg.Add(1)
+			go func() {
+				defer p.wg.Done()
+				defer wg.Done()
+
+				for v := range in.ch {
+					u, ok := apply(v)
+					if !ok || !send(p.ctx, out, u) {
+						drain(in.ch)
+						return
+					}
+					atomic.AddInt64(&stats.Out, 1)
+				}
+			}()
+		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			wg.Wait()
+			close(out)
+		}()
+		return newOutput[U](p, out)
+	}
+
+	// each item gets a slot in input order, which is filled by a worker
+	type job struct {
+		v    T
+		slot chan U
+	}
+	jobs := make(chan job)
+	slots := make(chan chan U, workers)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(jobs)
+		defer close(slots)
+
+		for v := range in.ch {
+			slot := make(chan U, 1)
+			if !send(p.ctx, slots, slot) || !send(p.ctx, jobs, job{v: v, slot: slot}) {
+				drain(in.ch)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+
+			for j := range jobs {
+				u, ok := apply(j.v)
+				if !ok {
+					close(j.slot)
+					continue
+				}
+				j.slot <- u
+			}
+		}()
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(out)
+
+		for slot := range slots {
+			var (
+				u  U
+				ok bool
+			)
+			select {
+			case u, ok = <-slot:
+			case <-p.ctx.Done():
+			}
+			if !ok || !send(p.ctx, out, u) {
+				for range slots {
+				}
+				return
+			}
+			atomic.AddInt64(&stats.Out, 1)
+		}
+	}()
+
+	return newOutput[U](p, out)
+}
+
+// Filter keeps items for which keep returns true
+func Filter[T any](in *Stage[T], name string, keep func(v T) bool) *Stage[T] {
+	in.consume()
+	p := in.p
+	stats := p.newStage(name, 1)
+	out := make(chan T)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(out)
+
+		for v := range in.ch {
+			atomic.AddInt64(&stats.In, 1)
+			start := time.Now()
+			ok := keep(v)
+			stats.busy(start)
+			if !ok {
+				continue
+			}
+			if !send(p.ctx, out, v) {
+				drain(in.ch)
+				return
+			}
+			atomic.AddInt64(&stats.Out, 1)
+		}
+	}()
+
+	return newOutput[T](p, out)
+}
+
+// Batch groups items into batches of size, a partial batch is emitted
+// after maxWait since its first item if maxWait > 0, or when input ends
+func Batch[T any](in *Stage[T], name string, size int, maxWait time.Duration) *Stage[[]T] {
+	if size <= 0 {
+		panic("size <= 0")
+	}
+
+	in.consume()
+	p := in.p
+	stats := p.newStage(name, 1)
+	out := make(chan []T)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(out)
+
+		var (
+			batch   []T
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+		flush := func() bool {
+			if timer != nil {
+				timer.Stop()
+				timer, timeout = nil, nil
+			}
+			if len(batch) == 0 {
+				return true
+			}
+			if !send(p.ctx, out, batch) {
+				return false
+			}
+			atomic.AddInt64(&stats.Out, 1)
+			batch = nil
+			return true
+		}
+
+		for {
+			select {
+			case v, ok := <-in.ch:
+				if !ok {
+					flush()
+					return
+				}
+				atomic.AddInt64(&stats.In, 1)
+				batch = append(batch, v)
+				if len(batch) == 1 && maxWait > 0 {
+					timer = time.NewTimer(maxWait)
+					timeout = timer.C
+				}
+				if len(batch) == size && !flush() {
+					drain(in.ch)
+					return
+				}
+			case <-timeout:
+				timer, timeout = nil, nil
+				if !flush() {
+					drain(in.ch)
+					return
+				}
+			}
+		}
+	}()
+
+	return newOutput[[]T](p, out)
+}
+
+// Sink consumes items by f
+func Sink[T any](in *Stage[T], name string, f func(ctx context.Context, v T) error) {
+	in.consume()
+	p := in.p
+	stats := p.newStage(name, 1)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		for v := range in.ch {
+			if p.ctx.Err() != nil {
+				continue
+			}
+			atomic.AddInt64(&stats.In, 1)
+			start := time.Now()
+			err := f(p.ctx, v)
+			stats.busy(start)
+			if err != nil {
+				p.fail(stats, err)
+			}
+		}
+	}()
+}
+
+// drain unblocks the upstream after cancellation, until it closes ch
+func drain[T any](ch <-chan T) {
+	for range ch {
+	}
+}
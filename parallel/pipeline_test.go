@@ -0,0 +1,135 @@
+package parallel
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func rangeSource(p *Pipeline, n int, emitted *int64) *Stage[int] {
+	return Source(p, "source", func(ctx context.Context, emit func(int) bool) error {
+		for i := 0; i < n; i++ {
+			if !emit(i) {
+				return nil
+			}
+			if emitted != nil {
+				atomic.AddInt64(emitted, 1)
+			}
+		}
+		return nil
+	})
+}
+
+func TestPipeline(t *testing.T) {
+	for _, ordered := range []bool{false, true} {
+		p := NewPipeline(context.Background())
+		doubled := Map(rangeSource(p, 1000, nil), "double", 8, ordered, func(ctx context.Context, v int) (int, error) {
+			time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+			return v * 2, nil
+		})
+		even := Filter(doubled, "filter", func(v int) bool { return v%4 == 0 })
+		batches := Batch(even, "batch", 100, time.Second)
+
+		var got []int
+		Sink(batches, "sink", func(ctx context.Context, batch []int) error {
+			if len(batch) != 100 {
+				return errors.New("partial batch")
+			}
+			got = append(got, batch...)
+			return nil
+		})
+		if err := p.Wait(); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != 500 {
+			t.Fatal(len(got))
+		}
+		sum := 0
+		for i, v := range got {
+			if ordered && v != i*4 {
+				t.Fatal("out of order", i, v)
+			}
+			sum += v
+		}
+		if sum != 4*500*499/2 {
+			t.Fatal(sum)
+		}
+
+		stats := p.Stats()
+		if len(stats) != 5 || stats[1].In != 1000 || stats[1].Out != 1000 || stats[2].Out != 500 || stats[3].Out != 5 || stats[1].Workers != 8 {
+			t.Fatal(stats)
+		}
+	}
+}
+
+func TestPipelineError(t *testing.T) {
+	errBad := errors.New("bad")
+	for _, ordered := range []bool{false, true} {
+		// error cancels all stages, including an endless source
+		p := NewPipeline(context.Background())
+		s := rangeSource(p, 1<<60, nil)
+		m := Map(s, "map", 4, ordered, func(ctx context.Context, v int) (int, error) {
+			if v == 100 {
+				return 0, errBad
+			}
+			return v, nil
+		})
+		Sink(m, "sink", func(ctx context.Context, v int) error { return nil })
+
+		if err := p.Wait(); err != errBad {
+			t.Fatal(err)
+		}
+		if stats := p.Stats(); stats[1].Errors != 1 {
+			t.Fatal(stats)
+		}
+	}
+}
+
+func TestPipelineBackpressure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := NewPipeline(ctx)
+
+	var emitted int64
+	s := rangeSource(p, 1<<60, &emitted)
+	m := Map(s, "map", 2, true, func(ctx context.Context, v int) (int, error) { return v, nil })
+	Sink(m, "sink", func(ctx context.Context, v int) error {
+		time.Sleep(time.Millisecond * 10)
+		return nil
+	})
+
+	time.Sleep(time.Millisecond * 200)
+	cancel()
+	if err := p.Wait(); err != context.Canceled {
+		t.Fatal(err)
+	}
+	// at most about 20 consumed, plus items in channels and stages
+	if n := atomic.LoadInt64(&emitted); n > 40 {
+		t.Fatal(n)
+	}
+}
+
+func TestPipelineNoSink(t *testing.T) {
+	p := NewPipeline(context.Background())
+	var mapped int64
+	Map(rangeSource(p, 100, nil), "map", 2, true, func(ctx context.Context, v int) (int, error) {
+		atomic.AddInt64(&mapped, 1)
+		return v, nil
+	})
+
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- p.Wait()
+	}()
+	select {
+	case err := <-doneCh:
+		if err != nil || atomic.LoadInt64(&mapped) != 100 {
+			t.Fatal(err, mapped)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait blocked")
+	}
+}
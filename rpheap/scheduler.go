@@ -0,0 +1,278 @@
+package rpheap
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zhiqiangxu/util"
+)
+
+// Clock abstracts time for Scheduler
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a channel that fires after d, and a func to stop it
+	NewTimer(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	t := time.NewTimer(d)
+	return t.C, t.Stop
+}
+
+// RealClock is the Clock of package time
+var RealClock Clock = realClock{}
+
+type schedEntry struct {
+	when time.Time
+	seq  uint64 // FIFO for the same deadline
+	t    *Timer // nil if stopped or reset
+}
+
+func schedLess(a, b schedEntry) bool {
+	return a.when.Before(b.when) || (a.when.Equal(b.when) && a.seq < b.seq)
+}
+
+// Scheduler keeps deadlines of many timers in a THeap driven by a single goroutine,
+// instead of a runtime timer per timeout, callbacks run on a bounded worker pool.
+type Scheduler struct {
+	clock  Clock
+	pool   *util.WorkerPool
+	wakeCh chan struct{}
+	doneCh chan struct{}
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	heap   *THeap[schedEntry]
+	seq    uint64
+	dead   int // entries of stopped or reset timers not popped yet
+	closed bool
+}
+
+// Timer is a timer of Scheduler
+type Timer struct {
+	s *Scheduler
+	f func()
+	h *THandle[schedEntry] // nil if not pending
+}
+
+// NewScheduler is ctor for Scheduler, workers bounds the goroutines running callbacks
+func NewScheduler(clock Clock, workers int) *Scheduler {
+	s := &Scheduler{
+		clock:  clock,
+		pool:   util.NewWorkerPoolWithConf(util.WorkerPoolConf{Size: workers, QueueSize: workers * 64}),
+		wakeCh: make(chan struct{}, 1),
+		doneCh: make(chan struct{}),
+		heap:   NewTHeap(schedLess),
+	}
+	util.GoFunc(&s.wg, s.loop)
+	return s
+}
+
+// AfterFunc calls f on the worker pool after d
+func (s *Scheduler) AfterFunc(d time.Duration, f func()) *Timer {
+	t := &Timer{s: s, f: f}
+	t.Reset(d)
+	return t
+}
+
+// Pending returns the number of pending timers
+func (s *Scheduler) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.heap.Size() - s.dead
+}
+
+// Close stops the scheduler, pending timers never fire,
+// callbacks already dispatched are waited for, so it must not be called from a callback.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.doneCh)
+	s.wg.Wait()
+	s.pool.Close()
+}
+
+// Stop prevents the timer from firing, returns false if it has fired or been stopped.
+// It takes O(1), the heap entry is popped as dead by the scheduler goroutine.
+func (t *Timer) Stop() bool {
+	s := t.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t.h == nil {
+		return false
+	}
+	s.killLocked(t)
+	return true
+}
+
+// killLocked decreases the entry of t to the minimum, so that it's popped at once
+func (s *Scheduler) killLocked(t *Timer) {
+	s.heap.DecreaseKey(t.h, schedEntry{})
+	t.h = nil
+	s.dead++
+	s.wake()
+}
+
+func (s *Scheduler) wake() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// Reset the timer to fire after d, returns whether it was pending.
+// It takes O(1), an earlier deadline is a DecreaseKey, a later one kills the entry and inserts a new one.
+func (t *Timer) Reset(d time.Duration) (pending bool) {
+	s := t.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.seq++
+	e := schedEntry{when: s.clock.Now().Add(d), seq: s.seq, t: t}
+	pending = t.h != nil
+	if pending && !schedLess(t.h.item, e) {
+		s.heap.DecreaseKey(t.h, e)
+	} else {
+		if pending {
+			s.killLocked(t)
+		}
+		t.h = s.heap.Insert(e)
+	}
+
+	if s.heap.Min() == t.h {
+		s.wake()
+	}
+	return
+}
+
+func (s *Scheduler) loop() {
+	var (
+		fire     []func()
+		timerC   <-chan time.Time
+		stopFunc func() bool
+	)
+	for {
+		s.mu.Lock()
+		now := s.clock.Now()
+		for s.heap.Size() > 0 {
+			min := s.heap.Min()
+			if min.item.when.After(now) {
+				break
+			}
+			s.heap.DeleteMin()
+			t := min.item.t
+			if t == nil {
+				s.dead--
+				continue
+			}
+			t.h = nil
+			fire = append(fire, t.f)
+		}
+
+		timerC, stopFunc = nil, nil
+		if s.heap.Size() > 0 {
+			timerC, stopFunc = s.clock.NewTimer(s.heap.Min().item.when.Sub(now))
+		}
+		s.mu.Unlock()
+
+		for i, f := range fire {
+			// blocks if the pool is full, which delays later timers instead of piling up
+			if s.pool.Run(f) != nil {
+				return
+			}
+			fire[i] = nil
+		}
+		fire = fire[:0]
+
+		select {
+		case <-timerC:
+		case <-s.wakeCh:
+			if stopFunc != nil {
+				stopFunc()
+			}
+		case <-s.doneCh:
+			if stopFunc != nil {
+				stopFunc()
+			}
+			return
+		}
+	}
+}
+
+// FakeClock is a Clock advanced manually, for tests
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]bool
+}
+
+type fakeTimer struct {
+	when time.Time
+	ch   chan time.Time
+}
+
+// NewFakeClock is ctor for FakeClock
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, timers: make(map[*fakeTimer]bool)}
+}
+
+// Now implements Clock
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// NewTimer implements Clock
+func (c *FakeClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{when: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+	} else {
+		c.timers[t] = true
+	}
+	return t.ch, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		pending := c.timers[t]
+		delete(c.timers, t)
+		return pending
+	}
+}
+
+// Advance the clock by d, firing timers due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for t := range c.timers {
+		if !t.when.After(c.now) {
+			t.ch <- c.now
+			delete(c.timers, t)
+		}
+	}
+}
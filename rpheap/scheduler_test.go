@@ -0,0 +1,151 @@
+package rpheap
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestScheduler(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	s := NewScheduler(clock, 2)
+	defer s.Close()
+
+	var (
+		mu    sync.Mutex
+		fired []int
+	)
+	firedCh := make(chan int, 10)
+	timer := func(i int, d time.Duration) *Timer {
+		return s.AfterFunc(d, func() {
+			mu.Lock()
+			fired = append(fired, i)
+			mu.Unlock()
+			firedCh <- i
+		})
+	}
+	expect := func(n int) {
+		for i := 0; i < n; i++ {
+			select {
+			case <-firedCh:
+			case <-time.After(time.Second):
+				t.Fatalf("timer not fired")
+			}
+		}
+		select {
+		case i := <-firedCh:
+			t.Fatalf("timer %d fired unexpectedly", i)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	t1 := timer(1, time.Second)
+	t2 := timer(2, 2*time.Second)
+	t3 := timer(3, 3*time.Second)
+	t4 := timer(4, 4*time.Second)
+
+	// earlier is a DecreaseKey, later kills the old entry
+	assert.Assert(t, t3.Reset(500*time.Millisecond))
+	assert.Assert(t, t1.Reset(5*time.Second))
+	assert.Assert(t, t2.Stop())
+	assert.Assert(t, !t2.Stop())
+	assert.Equal(t, s.Pending(), 3)
+
+	clock.Advance(time.Second)
+	expect(1)
+	assert.Assert(t, !t3.Stop())
+
+	clock.Advance(3 * time.Second)
+	expect(1)
+
+	clock.Advance(time.Second)
+	expect(1)
+	assert.Assert(t, !t4.Reset(0))
+	expect(1)
+
+	mu.Lock()
+	assert.DeepEqual(t, fired, []int{3, 4, 1, 4})
+	mu.Unlock()
+	assert.Equal(t, s.Pending(), 0)
+}
+
+func TestSchedulerMany(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	s := NewScheduler(clock, 4)
+
+	const n = 10000
+	var wg sync.WaitGroup
+	timers := make([]*Timer, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		// none is due before it's reset, otherwise it fires twice
+		timers[i] = s.AfterFunc(time.Duration(i+1)*time.Millisecond, wg.Done)
+	}
+	// push half of them later and pull the rest earlier
+	for i, timer := range timers {
+		if i%2 == 0 {
+			timer.Reset(time.Duration(n+i) * time.Millisecond)
+		} else {
+			timer.Reset(time.Duration(i/2+1) * time.Millisecond)
+		}
+	}
+
+	for i := 0; i < 2*n; i += 100 {
+		clock.Advance(100 * time.Millisecond)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timers not fired")
+	}
+	s.Close()
+
+	// stopped after close
+	assert.Assert(t, !timers[0].Reset(time.Second))
+}
+
+func TestSchedulerResetBounded(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	s := NewScheduler(clock, 1)
+	defer s.Close()
+
+	const n = 100
+	timers := make([]*Timer, n)
+	for i := range timers {
+		timers[i] = s.AfterFunc(time.Hour, func() {})
+	}
+	for round := 0; round < 100; round++ {
+		for i, timer := range timers {
+			// alternate later and earlier deadlines
+			timer.Reset(time.Hour + time.Duration((round%2)*n+i)*time.Millisecond)
+		}
+		assert.Equal(t, s.Pending(), n)
+	}
+
+	// dead entries are popped
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mu.Lock()
+		size := s.heap.Size()
+		s.mu.Unlock()
+		if size == n {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("heap size %d", size)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for i, timer := range timers {
+		assert.Assert(t, timer.Stop())
+		assert.Equal(t, s.Pending(), n-i-1)
+	}
+}
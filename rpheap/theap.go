@@ -0,0 +1,260 @@
+package rpheap
+
+// THandle is a handle of an item in THeap, for DecreaseKey and Delete
+type THandle[T any] struct {
+	item                T
+	left, right, parent *THandle[T] // half tree in binary form, right is nil for roots
+	rank                int
+	owner               *tHeapOwner // nil once removed
+}
+
+// tHeapOwner identifies a heap, it's forwarded to the melding heap on Meld,
+// so that handles needn't be walked
+type tHeapOwner struct {
+	fwd *tHeapOwner
+}
+
+func (o *tHeapOwner) resolve() *tHeapOwner {
+	for o.fwd != nil {
+		if o.fwd.fwd != nil {
+			o.fwd = o.fwd.fwd
+		}
+		o = o.fwd
+	}
+	return o
+}
+
+// Value returns the item
+func (x *THandle[T]) Value() T {
+	return x.item
+}
+
+// THeap is a typed rank pairing heap (type 1 rank rule) ordered by less,
+// which takes O(1) for Insert, FindMin, Meld, DecreaseKey, and O(log n) for DeleteMin, Delete, all amortized.
+type THeap[T any] struct {
+	less  func(a, b T) bool
+	owner *tHeapOwner
+	roots []*THandle[T]
+	min   *THandle[T]
+	size  int
+
+	// work counters for verifying the amortized bounds in tests
+	links       int
+	rankUpdates int
+}
+
+// NewTHeap is ctor for THeap
+func NewTHeap[T any](less func(a, b T) bool) *THeap[T] {
+	return &THeap[T]{less: less, owner: &tHeapOwner{}}
+}
+
+// Insert into the heap, returns the handle of item
+func (h *THeap[T]) Insert(item T) *THandle[T] {
+	x := &THandle[T]{item: item, owner: h.owner}
+	h.addRoot(x)
+	h.size++
+	return x
+}
+
+func (h *THeap[T]) addRoot(x *THandle[T]) {
+	h.roots = append(h.roots, x)
+	if h.min == nil || h.less(x.item, h.min.item) {
+		h.min = x
+	}
+}
+
+// FindMin from the heap
+func (h *THeap[T]) FindMin() T {
+	if h.min == nil {
+		panic("FindMin on empty heap")
+	}
+	return h.min.item
+}
+
+// Min returns the handle of the min item, nil if empty
+func (h *THeap[T]) Min() *THandle[T] {
+	return h.min
+}
+
+// Meld a into h, a will be empty, handles of a now belong to h
+func (h *THeap[T]) Meld(a *THeap[T]) {
+	if a == h {
+		return
+	}
+	for _, r := range a.roots {
+		h.addRoot(r)
+	}
+	h.size += a.size
+	a.owner.fwd = h.owner
+	a.Clear()
+}
+
+// DeleteMin from the heap
+func (h *THeap[T]) DeleteMin() T {
+	if h.min == nil {
+		panic("DeleteMin on empty heap")
+	}
+
+	m := h.min
+	h.size--
+
+	// the left spine of m becomes half trees, together with other roots they
+	// are linked by rank until all ranks are distinct
+	var bucket []*THandle[T]
+	put := func(x *THandle[T]) {
+		for {
+			for len(bucket) <= x.rank {
+				bucket = append(bucket, nil)
+			}
+			if bucket[x.rank] == nil {
+				bucket[x.rank] = x
+				return
+			}
+			y := bucket[x.rank]
+			bucket[x.rank] = nil
+			x = h.link(x, y)
+		}
+	}
+	for x := m.left; x != nil; {
+		next := x.right
+		x.right, x.parent = nil, nil
+		x.rank = rankOf(x.left) + 1
+		put(x)
+		x = next
+	}
+	for _, r := range h.roots {
+		if r != m {
+			put(r)
+		}
+	}
+
+	h.roots = h.roots[:0]
+	h.min = nil
+	for _, r := range bucket {
+		if r != nil {
+			h.addRoot(r)
+		}
+	}
+
+	m.left, m.owner = nil, nil
+	return m.item
+}
+
+// DecreaseKey replaces the item of x with a smaller or equal one
+func (h *THeap[T]) DecreaseKey(x *THandle[T], item T) {
+	h.check(x)
+	if h.less(x.item, item) {
+		panic("DecreaseKey with a larger item")
+	}
+
+	x.item = item
+	if x.parent == nil {
+		if h.less(x.item, h.min.item) {
+			h.min = x
+		}
+		return
+	}
+
+	h.cut(x)
+	h.addRoot(x)
+}
+
+// Delete x from the heap
+func (h *THeap[T]) Delete(x *THandle[T]) {
+	h.check(x)
+
+	if x.parent != nil {
+		h.cut(x)
+		h.roots = append(h.roots, x)
+	}
+	// x becomes the min regardless of order
+	h.min = x
+	h.DeleteMin()
+}
+
+// Size of the heap
+func (h *THeap[T]) Size() int {
+	return h.size
+}
+
+// Clear the heap, existing handles are invalid afterwards
+func (h *THeap[T]) Clear() {
+	h.owner = &tHeapOwner{}
+	h.roots = nil
+	h.min = nil
+	h.size = 0
+}
+
+func (h *THeap[T]) check(x *THandle[T]) {
+	if x.owner == nil || x.owner.resolve() != h.owner {
+		panic("handle not in heap")
+	}
+}
+
+// cut detaches x with its left subtree into a half tree, the right child of x takes its place,
+// then ranks are restored along the path to root
+func (h *THeap[T]) cut(x *THandle[T]) {
+	y := x.parent
+	u := x.right
+	if y.left == x {
+		y.left = u
+	} else {
+		y.right = u
+	}
+	if u != nil {
+		u.parent = y
+	}
+	x.right, x.parent = nil, nil
+	x.rank = rankOf(x.left) + 1
+
+	for ; y != nil; y = y.parent {
+		h.rankUpdates++
+		var k int
+		if y.parent == nil {
+			k = rankOf(y.left) + 1
+		} else {
+			r1, r2 := rankOf(y.left), rankOf(y.right)
+			if r1 == r2 {
+				k = r1 + 1
+			} else {
+				k = max(r1, r2)
+			}
+		}
+		if k >= y.rank {
+			break
+		}
+		y.rank = k
+	}
+}
+
+// link two half trees of the same rank
+func (h *THeap[T]) link(a, b *THandle[T]) *THandle[T] {
+	h.links++
+	winner, loser := a, b
+	if h.less(b.item, a.item) {
+		winner, loser = b, a
+	}
+
+	loser.right = winner.left
+	if loser.right != nil {
+		loser.right.parent = loser
+	}
+	loser.parent = winner
+	winner.left = loser
+	winner.rank++
+	return winner
+}
+
+func rankOf[T any](x *THandle[T]) int {
+	if x == nil {
+		return -1
+	}
+	return x.rank
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
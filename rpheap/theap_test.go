@@ -0,0 +1,161 @@
+package rpheap
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+type item struct {
+	key, id int
+}
+
+func itemLess(a, b item) bool {
+	return a.key < b.key || (a.key == b.key && a.id < b.id)
+}
+
+func TestTHeap(t *testing.T) {
+	h := NewTHeap(itemLess)
+	live := make(map[int]*THandle[item])
+	keys := make(map[int]int)
+
+	check := func() {
+		assert.Assert(t, h.Size() == len(live))
+		if len(live) == 0 {
+			return
+		}
+		var min *item
+		for id, key := range keys {
+			it := item{key: key, id: id}
+			if min == nil || itemLess(it, *min) {
+				min = &it
+			}
+		}
+		assert.Assert(t, h.FindMin() == *min, "min:%v got:%v", *min, h.FindMin())
+	}
+
+	nextID := 0
+	for i := 0; i < 20000; i++ {
+		switch op := rand.Intn(10); {
+		case op < 4 || len(live) == 0:
+			key := rand.Intn(1000)
+			live[nextID] = h.Insert(item{key: key, id: nextID})
+			keys[nextID] = key
+			nextID++
+		case op < 6:
+			for id, x := range live {
+				key := keys[id] - rand.Intn(100)
+				h.DecreaseKey(x, item{key: key, id: id})
+				keys[id] = key
+				break
+			}
+		case op < 8:
+			m := h.DeleteMin()
+			assert.Assert(t, keys[m.id] == m.key)
+			delete(live, m.id)
+			delete(keys, m.id)
+		default:
+			for id, x := range live {
+				h.Delete(x)
+				delete(live, id)
+				delete(keys, id)
+				break
+			}
+		}
+		if i%100 == 0 {
+			check()
+		}
+	}
+
+	// drains in order
+	var drained []item
+	for h.Size() > 0 {
+		drained = append(drained, h.DeleteMin())
+	}
+	assert.Assert(t, sort.SliceIsSorted(drained, func(i, j int) bool { return itemLess(drained[i], drained[j]) }))
+}
+
+func TestTHeapMeld(t *testing.T) {
+	less := func(a, b int) bool { return a < b }
+	h1, h2 := NewTHeap(less), NewTHeap(less)
+	for i := 0; i < 10; i++ {
+		h1.Insert(i * 2)
+	}
+	x := h2.Insert(101)
+	for i := 0; i < 10; i++ {
+		h2.Insert(i*2 + 1)
+	}
+
+	h1.Meld(h2)
+	assert.Assert(t, h2.Size() == 0 && h1.Size() == 21)
+
+	// handles of h2 now belong to h1
+	h1.DecreaseKey(x, -1)
+	assert.Assert(t, h1.DeleteMin() == -1)
+	for i := 0; i < 20; i++ {
+		assert.Assert(t, h1.DeleteMin() == i)
+	}
+
+	// removed handle
+	y := h1.Insert(1)
+	h1.Delete(y)
+	func() {
+		defer func() {
+			assert.Assert(t, recover() != nil)
+		}()
+		h1.Delete(y)
+	}()
+}
+
+// TestTHeapBounds verifies the amortized bounds by counting work:
+// links per DeleteMin is O(log n), rank updates per DecreaseKey is O(1).
+func TestTHeapBounds(t *testing.T) {
+	for _, n := range []int{1 << 10, 1 << 14, 1 << 17} {
+		h := NewTHeap(func(a, b int) bool { return a < b })
+		handles := make([]*THandle[int], n)
+		for i := range handles {
+			handles[i] = h.Insert(rand.Intn(n * 10))
+		}
+
+		// build trees first so that DecreaseKey has something to cut
+		for i := 0; i < n/4; i++ {
+			h.DeleteMin()
+		}
+
+		h.links, h.rankUpdates = 0, 0
+		decreases := 0
+		for _, x := range handles {
+			if x.owner == nil {
+				continue
+			}
+			h.DecreaseKey(x, x.Value()-rand.Intn(n))
+			decreases++
+		}
+		perDecrease := float64(h.rankUpdates) / float64(decreases)
+
+		h.links = 0
+		deletes := h.Size()
+		for h.Size() > 0 {
+			h.DeleteMin()
+		}
+		perDelete := float64(h.links) / float64(deletes) / math.Log2(float64(n))
+
+		t.Logf("n:%d rank updates per DecreaseKey:%.2f links per DeleteMin/log n:%.2f", n, perDecrease, perDelete)
+		assert.Assert(t, perDecrease < 4, "perDecrease:%v", perDecrease)
+		assert.Assert(t, perDelete < 3, "perDelete:%v", perDelete)
+	}
+}
+
+func BenchmarkTHeap(b *testing.B) {
+	h := NewTHeap(func(a, b int) bool { return a < b })
+	for i := 0; i < b.N; i++ {
+		x := h.Insert(rand.Int())
+		h.DecreaseKey(x, x.Value()/2)
+		if h.Size() > 1000 {
+			h.DeleteMin()
+		}
+	}
+}
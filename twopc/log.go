@@ -0,0 +1,287 @@
+package twopc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zhiqiangxu/util/diskqueue"
+)
+
+// RecordType of log records
+type RecordType byte
+
+const (
+	// RecordDecision is written by TM before sending the decision
+	RecordDecision RecordType = iota
+	// RecordEnd is written by TM when all RMs have acknowledged the decision
+	RecordEnd
+	// RecordPrepared is written by RM before sending Prepared
+	RecordPrepared
+	// RecordCommitted is written by RM after committing
+	RecordCommitted
+	// RecordAborted is written by RM before voting no or after aborting
+	RecordAborted
+)
+
+// Record of Log
+type Record struct {
+	Type     RecordType
+	Txn      string
+	Decision Decision `json:",omitempty"`
+	RMs      []string `json:",omitempty"`
+}
+
+// Log is the durable log of TM or RM, a record should be durable when Append returns
+type Log interface {
+	Append(r Record) error
+	// Replay calls f with all records in order
+	Replay(f func(r Record)) error
+	// Compact replaces all records with live, atomically and durably
+	Compact(live []Record) error
+	Close() error
+}
+
+// MemLog is a Log in memory, it survives restarts of TM or RM in the same process
+type MemLog struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+// NewMemLog is ctor for MemLog
+func NewMemLog() *MemLog {
+	return &MemLog{}
+}
+
+// Append implements Log
+func (l *MemLog) Append(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records = append(l.records, r)
+	return nil
+}
+
+// Replay implements Log
+func (l *MemLog) Replay(f func(r Record)) error {
+	l.mu.Lock()
+	records := append([]Record(nil), l.records...)
+	l.mu.Unlock()
+
+	for _, r := range records {
+		f(r)
+	}
+	return nil
+}
+
+// Compact implements Log
+func (l *MemLog) Compact(live []Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records = append([]Record(nil), live...)
+	return nil
+}
+
+// Close implements Log
+func (l *MemLog) Close() error {
+	return nil
+}
+
+// DiskLog is a Log on diskqueue, records are synced before Append returns.
+// Each Compact writes a new queue under dir, and switches to it by replacing the file current.
+type DiskLog struct {
+	dir string
+
+	mu  sync.RWMutex
+	gen uint64
+	q   *diskqueue.Queue
+}
+
+const diskLogCurrent = "current"
+
+// NewDiskLog is ctor for DiskLog, records are kept under dir
+func NewDiskLog(dir string) (l *DiskLog, err error) {
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return
+	}
+
+	var gen uint64
+	data, err := os.ReadFile(filepath.Join(dir, diskLogCurrent))
+	switch {
+	case err == nil:
+		gen, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return
+		}
+	case os.IsNotExist(err):
+		err = nil
+	default:
+		return
+	}
+
+	// remove queues left by an interrupted Compact
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() && e.Name() != diskLogGenName(gen) {
+			err = os.RemoveAll(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	q, err := diskqueue.New(diskqueue.Conf{Directory: filepath.Join(dir, diskLogGenName(gen))})
+	if err != nil {
+		return
+	}
+	l = &DiskLog{dir: dir, gen: gen, q: q}
+	return
+}
+
+func diskLogGenName(gen uint64) string {
+	return fmt.Sprintf("gen%d", gen)
+}
+
+// Append implements Log
+func (l *DiskLog) Append(r Record) (err error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+
+	l.mu.RLock()
+	_, err = l.q.PutSync(data)
+	l.mu.RUnlock()
+	return
+}
+
+// Compact implements Log
+func (l *DiskLog) Compact(live []Record) (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	gen := l.gen + 1
+	q, err := diskqueue.New(diskqueue.Conf{Directory: filepath.Join(l.dir, diskLogGenName(gen))})
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			q.Delete()
+		}
+	}()
+
+	for _, r := range live {
+		var data []byte
+		data, err = json.Marshal(r)
+		if err != nil {
+			return
+		}
+		_, err = q.PutSync(data)
+		if err != nil {
+			return
+		}
+	}
+
+	err = l.writeCurrent(gen)
+	if err != nil {
+		return
+	}
+
+	// the old queue is removed on the next open if this fails
+	l.q.Delete()
+	l.q, l.gen = q, gen
+	return
+}
+
+// writeCurrent replaces the file current with gen, atomically and durably
+func (l *DiskLog) writeCurrent(gen uint64) (err error) {
+	path := filepath.Join(l.dir, diskLogCurrent)
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+
+	_, err = file.WriteString(strconv.FormatUint(gen, 10))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return
+	}
+
+	// make the rename durable
+	d, err := os.Open(l.dir)
+	if err != nil {
+		return
+	}
+	err = d.Sync()
+	d.Close()
+	return
+}
+
+// Replay implements Log
+func (l *DiskLog) Replay(f func(r Record)) (err error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	stat := l.q.Stat()
+	var total uint64
+	for i := int(stat.MinValidIndex); i < int(stat.FileCount); i++ {
+		total += l.q.FileMeta(i).MsgCount
+	}
+	if total == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := l.q.StreamRead(ctx, l.q.FileMeta(int(stat.MinValidIndex)).StartOffset)
+	if err != nil {
+		return
+	}
+	for i := uint64(0); i < total; i++ {
+		data, ok := <-ch
+		if !ok {
+			err = errLogTruncated
+			return
+		}
+		var r Record
+		err = json.Unmarshal(data.Bytes, &r)
+		if err != nil {
+			return
+		}
+		f(r)
+	}
+	return
+}
+
+// Close implements Log
+func (l *DiskLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.q.Close()
+	return nil
+}
@@ -0,0 +1,297 @@
+package twopc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zhiqiangxu/util"
+)
+
+// Resource is the local part of transactions, Commit and Abort must be idempotent
+type Resource interface {
+	// Prepare votes yes if nil, the transaction must be able to commit afterwards, even after restart
+	Prepare(txn string) error
+	Commit(txn string)
+	Abort(txn string)
+}
+
+// RMConf for RM
+type RMConf struct {
+	Name string
+	// name of TM
+	TM        string
+	Log       Log
+	Transport Transport
+	// interval of querying TM for in-doubt transactions, default 200ms
+	QueryInterval time.Duration
+	// committed or aborted transactions are forgotten after Retention, which should be
+	// no shorter than that of TM, so that duplicate messages about them are answered, default 1min
+	Retention time.Duration
+	// the log is compacted once it has CompactThreshold records and twice the live ones, default 1024
+	CompactThreshold int
+}
+
+// RM is the resource manager
+type RM struct {
+	conf   RMConf
+	res    Resource
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu         sync.Mutex
+	closed     bool
+	states     map[string]RMState
+	preparedAt map[string]time.Time
+	doneAt     map[string]time.Time // when committed or aborted
+	logged     int                  // records in the log
+}
+
+// NewRM is ctor for RM, transactions prepared but not decided in the log are in doubt,
+// they're resolved by querying TM. The log is not closed by RM.
+func NewRM(conf RMConf, res Resource) (rm *RM, err error) {
+	if conf.QueryInterval <= 0 {
+		conf.QueryInterval = 200 * time.Millisecond
+	}
+	if conf.Retention <= 0 {
+		conf.Retention = time.Minute
+	}
+	if conf.CompactThreshold <= 0 {
+		conf.CompactThreshold = 1024
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rm = &RM{conf: conf, res: res, ctx: ctx, cancel: cancel, states: make(map[string]RMState), preparedAt: make(map[string]time.Time), doneAt: make(map[string]time.Time)}
+	now := time.Now()
+	err = conf.Log.Replay(func(r Record) {
+		rm.logged++
+		switch r.Type {
+		case RecordPrepared:
+			rm.states[r.Txn] = RMPrepared
+			// query at once
+			rm.preparedAt[r.Txn] = time.Time{}
+		case RecordCommitted:
+			rm.states[r.Txn] = RMCommitted
+			delete(rm.preparedAt, r.Txn)
+			// retained again from now on
+			rm.doneAt[r.Txn] = now
+		case RecordAborted:
+			rm.states[r.Txn] = RMAborted
+			delete(rm.preparedAt, r.Txn)
+			rm.doneAt[r.Txn] = now
+		}
+	})
+	if err != nil {
+		cancel()
+		rm = nil
+		return
+	}
+
+	util.GoFunc(&rm.wg, rm.queryLoop)
+	return
+}
+
+// Handle a message from TM
+func (rm *RM) Handle(msg Message) {
+	rm.mu.Lock()
+	reply, ok := rm.handleLocked(msg)
+	rm.mu.Unlock()
+
+	if ok {
+		rm.conf.Transport.Send(rm.conf.TM, Message{Type: reply, Txn: msg.Txn, From: rm.conf.Name})
+	}
+}
+
+func (rm *RM) handleLocked(msg Message) (reply MsgType, ok bool) {
+	if rm.closed {
+		return
+	}
+
+	txn := msg.Txn
+	state := rm.states[txn]
+	switch msg.Type {
+	case MsgPrepare:
+		switch state {
+		case RMWorking:
+			if rm.res.Prepare(txn) != nil {
+				if rm.abortLocked(txn) == nil {
+					reply, ok = MsgAborted, true
+				}
+				return
+			}
+			// the vote is durable before sent
+			if rm.appendLocked(Record{Type: RecordPrepared, Txn: txn}) != nil {
+				return
+			}
+			rm.states[txn] = RMPrepared
+			rm.preparedAt[txn] = time.Now()
+			reply, ok = MsgPrepared, true
+		case RMPrepared:
+			reply, ok = MsgPrepared, true
+		case RMAborted:
+			reply, ok = MsgAborted, true
+		}
+	case MsgCommit:
+		switch state {
+		case RMPrepared:
+			rm.res.Commit(txn)
+			if rm.appendLocked(Record{Type: RecordCommitted, Txn: txn}) != nil {
+				// still prepared, Commit is resent
+				return
+			}
+			rm.states[txn] = RMCommitted
+			delete(rm.preparedAt, txn)
+			rm.doneAt[txn] = time.Now()
+			reply, ok = MsgAck, true
+		case RMWorking:
+			// a prepared one is never forgotten, so it has been committed and forgotten
+			reply, ok = MsgAck, true
+		case RMCommitted:
+			reply, ok = MsgAck, true
+		}
+	case MsgAbort:
+		switch state {
+		case RMWorking, RMPrepared:
+			if rm.abortLocked(txn) == nil {
+				reply, ok = MsgAck, true
+			}
+		case RMAborted:
+			reply, ok = MsgAck, true
+		}
+	}
+	return
+}
+
+func (rm *RM) abortLocked(txn string) (err error) {
+	err = rm.appendLocked(Record{Type: RecordAborted, Txn: txn})
+	if err != nil {
+		return
+	}
+	rm.res.Abort(txn)
+	rm.states[txn] = RMAborted
+	delete(rm.preparedAt, txn)
+	rm.doneAt[txn] = time.Now()
+	return
+}
+
+func (rm *RM) appendLocked(r Record) (err error) {
+	err = rm.conf.Log.Append(r)
+	if err == nil {
+		rm.logged++
+	}
+	return
+}
+
+// Abort txn spontaneously, it fails with ErrPrepared once voted yes
+func (rm *RM) Abort(txn string) (err error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if rm.closed {
+		err = ErrClosed
+		return
+	}
+
+	switch rm.states[txn] {
+	case RMWorking:
+		err = rm.abortLocked(txn)
+	case RMPrepared, RMCommitted:
+		err = ErrPrepared
+	}
+	return
+}
+
+// State returns the state of txn, RMWorking if forgotten
+func (rm *RM) State(txn string) RMState {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	return rm.states[txn]
+}
+
+// InDoubt returns the number of prepared transactions not decided yet
+func (rm *RM) InDoubt() int {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	return len(rm.preparedAt)
+}
+
+// Txns returns the number of transactions remembered, including done ones within Retention
+func (rm *RM) Txns() int {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	return len(rm.states)
+}
+
+// Close the RM
+func (rm *RM) Close() {
+	rm.mu.Lock()
+	rm.closed = true
+	rm.mu.Unlock()
+
+	rm.cancel()
+	rm.wg.Wait()
+}
+
+// queryLoop queries TM for transactions prepared longer than QueryInterval,
+// and forgets transactions done longer than Retention.
+func (rm *RM) queryLoop() {
+	ticker := time.NewTicker(rm.conf.QueryInterval)
+	defer ticker.Stop()
+
+	for {
+		var txns []string
+		now := time.Now()
+		rm.mu.Lock()
+		for txn, at := range rm.preparedAt {
+			if now.Sub(at) >= rm.conf.QueryInterval {
+				txns = append(txns, txn)
+			}
+		}
+		for txn, at := range rm.doneAt {
+			if now.Sub(at) >= rm.conf.Retention {
+				delete(rm.states, txn)
+				delete(rm.doneAt, txn)
+			}
+		}
+		rm.compactLocked()
+		rm.mu.Unlock()
+
+		for _, txn := range txns {
+			rm.conf.Transport.Send(rm.conf.TM, Message{Type: MsgQuery, Txn: txn, From: rm.conf.Name})
+		}
+
+		select {
+		case <-ticker.C:
+		case <-rm.ctx.Done():
+			return
+		}
+	}
+}
+
+// compactLocked rewrites the log with records of the transactions remembered
+func (rm *RM) compactLocked() {
+	if rm.closed || rm.logged < rm.conf.CompactThreshold || rm.logged < 2*len(rm.states) {
+		return
+	}
+
+	live := make([]Record, 0, len(rm.states))
+	for txn, state := range rm.states {
+		r := Record{Txn: txn}
+		switch state {
+		case RMPrepared:
+			r.Type = RecordPrepared
+		case RMCommitted:
+			r.Type = RecordCommitted
+		case RMAborted:
+			r.Type = RecordAborted
+		}
+		live = append(live, r)
+	}
+	if rm.conf.Log.Compact(live) == nil {
+		rm.logged = len(live)
+	}
+}
@@ -0,0 +1,384 @@
+package twopc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zhiqiangxu/util"
+)
+
+// TMConf for TM
+type TMConf struct {
+	Name      string
+	Log       Log
+	Transport Transport
+	// Commit aborts if not all RMs are prepared within PrepareTimeout, default 1s
+	PrepareTimeout time.Duration
+	// interval of resending Prepare and unacknowledged decisions, default 100ms
+	ResendInterval time.Duration
+	// transactions acknowledged by all RMs are forgotten after Retention,
+	// messages about them are answered as presumed aborted afterwards,
+	// so Transport must not deliver messages delayed longer than it, default 1min
+	Retention time.Duration
+	// the log is compacted once it has CompactThreshold records and twice the live ones, default 1024
+	CompactThreshold int
+}
+
+// TM is the transaction manager
+type TM struct {
+	conf   TMConf
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+	txns   map[string]*tmTxn
+	logged int // records in the log
+}
+
+type tmTxn struct {
+	rms      []string
+	prepared map[string]bool // tmPrepared of the spec
+	aborted  bool            // some RM voted no
+	decision Decision        // 0 if undecided
+	acked    map[string]bool
+	ended    bool
+	endedAt  time.Time
+	voteCh   chan struct{}
+}
+
+func (t *tmTxn) hasRM(rm string) bool {
+	for _, r := range t.rms {
+		if r == rm {
+			return true
+		}
+	}
+	return false
+}
+
+// NewTM is ctor for TM, decisions in the log are recovered,
+// and those not acknowledged by all RMs are resent.
+// The log is not closed by TM.
+func NewTM(conf TMConf) (tm *TM, err error) {
+	if conf.PrepareTimeout <= 0 {
+		conf.PrepareTimeout = time.Second
+	}
+	if conf.ResendInterval <= 0 {
+		conf.ResendInterval = 100 * time.Millisecond
+	}
+	if conf.Retention <= 0 {
+		conf.Retention = time.Minute
+	}
+	if conf.CompactThreshold <= 0 {
+		conf.CompactThreshold = 1024
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tm = &TM{conf: conf, ctx: ctx, cancel: cancel, txns: make(map[string]*tmTxn)}
+	now := time.Now()
+	err = conf.Log.Replay(func(r Record) {
+		tm.logged++
+		switch r.Type {
+		case RecordDecision:
+			// presumed aborted ones have no RM to acknowledge
+			tm.txns[r.Txn] = &tmTxn{rms: r.RMs, decision: r.Decision, acked: make(map[string]bool), ended: len(r.RMs) == 0, endedAt: now}
+		case RecordEnd:
+			if t := tm.txns[r.Txn]; t != nil {
+				// retained again from now on
+				t.ended, t.endedAt = true, now
+			}
+		}
+	})
+	if err != nil {
+		cancel()
+		tm = nil
+		return
+	}
+
+	util.GoFunc(&tm.wg, tm.resendLoop)
+	return
+}
+
+// Commit runs two-phase commit of txn among rms and returns the decision,
+// which is durable but may not be known by all RMs yet. Nothing is decided if err is not nil.
+func (tm *TM) Commit(ctx context.Context, txn string, rms []string) (d Decision, err error) {
+	t := &tmTxn{prepared: make(map[string]bool), acked: make(map[string]bool), voteCh: make(chan struct{}, 1)}
+	for _, rm := range rms {
+		if !t.hasRM(rm) {
+			t.rms = append(t.rms, rm)
+		}
+	}
+
+	tm.mu.Lock()
+	if tm.closed {
+		tm.mu.Unlock()
+		err = ErrClosed
+		return
+	}
+	if tm.txns[txn] != nil {
+		tm.mu.Unlock()
+		err = ErrTxnExists
+		return
+	}
+	tm.txns[txn] = t
+	tm.mu.Unlock()
+
+	tm.send(txn, t.rms, MsgPrepare)
+
+	timer := time.NewTimer(tm.conf.PrepareTimeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(tm.conf.ResendInterval)
+	defer ticker.Stop()
+
+	// abort unless all RMs are prepared in time
+	d = DecisionAbort
+wait:
+	for {
+		tm.mu.Lock()
+		no, all := t.aborted, len(t.prepared) == len(t.rms)
+		var notPrepared []string
+		for _, rm := range t.rms {
+			if !t.prepared[rm] {
+				notPrepared = append(notPrepared, rm)
+			}
+		}
+		tm.mu.Unlock()
+
+		if no {
+			break
+		}
+		if all {
+			d = DecisionCommit
+			break
+		}
+
+		select {
+		case <-t.voteCh:
+		case <-ticker.C:
+			tm.send(txn, notPrepared, MsgPrepare)
+		case <-timer.C:
+			break wait
+		case <-ctx.Done():
+			break wait
+		case <-tm.ctx.Done():
+			d, err = 0, ErrClosed
+			return
+		}
+	}
+
+	tm.mu.Lock()
+	err = tm.decideLocked(txn, t, d)
+	if err != nil {
+		// forgotten like lost in a restart, so that RMs in doubt are answered as presumed aborted
+		delete(tm.txns, txn)
+	}
+	tm.mu.Unlock()
+	if err != nil {
+		d = 0
+		return
+	}
+
+	tm.send(txn, t.rms, decisionMsg(d))
+	return
+}
+
+// decideLocked logs the decision before it takes effect,
+// nothing is logged once closed, as if the TM crashed before deciding.
+func (tm *TM) decideLocked(txn string, t *tmTxn, d Decision) (err error) {
+	if tm.closed {
+		err = ErrClosed
+		return
+	}
+	err = tm.appendLocked(Record{Type: RecordDecision, Txn: txn, Decision: d, RMs: t.rms})
+	if err != nil {
+		return
+	}
+	t.decision = d
+	if len(t.rms) == 0 {
+		t.ended, t.endedAt = true, time.Now()
+	}
+	return
+}
+
+func (tm *TM) appendLocked(r Record) (err error) {
+	err = tm.conf.Log.Append(r)
+	if err == nil {
+		tm.logged++
+	}
+	return
+}
+
+// Handle a message from RM
+func (tm *TM) Handle(msg Message) {
+	var (
+		reply   MsgType
+		replyTo bool
+	)
+
+	tm.mu.Lock()
+	if tm.closed {
+		tm.mu.Unlock()
+		return
+	}
+
+	t := tm.txns[msg.Txn]
+	if t == nil {
+		if msg.Type == MsgPrepared || msg.Type == MsgQuery {
+			// not started or lost in a restart before deciding, presumed aborted
+			t = &tmTxn{acked: make(map[string]bool)}
+			if tm.decideLocked(msg.Txn, t, DecisionAbort) == nil {
+				tm.txns[msg.Txn] = t
+				reply, replyTo = MsgAbort, true
+			}
+		}
+		tm.mu.Unlock()
+		if replyTo {
+			tm.conf.Transport.Send(msg.From, Message{Type: reply, Txn: msg.Txn, From: tm.conf.Name})
+		}
+		return
+	}
+
+	switch msg.Type {
+	case MsgPrepared, MsgAborted:
+		if t.decision != 0 {
+			reply, replyTo = decisionMsg(t.decision), true
+			break
+		}
+		if !t.hasRM(msg.From) {
+			break
+		}
+		if msg.Type == MsgPrepared {
+			t.prepared[msg.From] = true
+		} else {
+			t.aborted = true
+		}
+		select {
+		case t.voteCh <- struct{}{}:
+		default:
+		}
+	case MsgQuery:
+		// an undecided txn is still running, the RM will query again
+		if t.decision != 0 {
+			reply, replyTo = decisionMsg(t.decision), true
+		}
+	case MsgAck:
+		if t.decision == 0 || !t.hasRM(msg.From) {
+			break
+		}
+		t.acked[msg.From] = true
+		if !t.ended && len(t.acked) >= len(t.rms) {
+			if tm.appendLocked(Record{Type: RecordEnd, Txn: msg.Txn}) == nil {
+				t.ended, t.endedAt = true, time.Now()
+			}
+		}
+	}
+	tm.mu.Unlock()
+
+	if replyTo {
+		tm.conf.Transport.Send(msg.From, Message{Type: reply, Txn: msg.Txn, From: tm.conf.Name})
+	}
+}
+
+// Decision returns the decision of txn, 0 if undecided, unknown or forgotten
+func (tm *TM) Decision(txn string) Decision {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if t := tm.txns[txn]; t != nil {
+		return t.decision
+	}
+	return 0
+}
+
+// Txns returns the number of transactions remembered, including ended ones within Retention
+func (tm *TM) Txns() int {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	return len(tm.txns)
+}
+
+// Close the TM, Commit in progress fails with ErrClosed without deciding
+func (tm *TM) Close() {
+	tm.mu.Lock()
+	tm.closed = true
+	tm.mu.Unlock()
+
+	tm.cancel()
+	tm.wg.Wait()
+}
+
+func (tm *TM) send(txn string, rms []string, typ MsgType) {
+	for _, rm := range rms {
+		tm.conf.Transport.Send(rm, Message{Type: typ, Txn: txn, From: tm.conf.Name})
+	}
+}
+
+// resendLoop resends decisions to RMs not acknowledged,
+// and forgets transactions ended longer than Retention.
+func (tm *TM) resendLoop() {
+	ticker := time.NewTicker(tm.conf.ResendInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-tm.ctx.Done():
+			return
+		}
+
+		pending := make(map[string][]string)
+		decisions := make(map[string]Decision)
+		now := time.Now()
+		tm.mu.Lock()
+		for txn, t := range tm.txns {
+			if t.ended {
+				if now.Sub(t.endedAt) >= tm.conf.Retention {
+					delete(tm.txns, txn)
+				}
+				continue
+			}
+			if t.decision == 0 {
+				continue
+			}
+			for _, rm := range t.rms {
+				if !t.acked[rm] {
+					pending[txn] = append(pending[txn], rm)
+				}
+			}
+			decisions[txn] = t.decision
+		}
+		tm.compactLocked()
+		tm.mu.Unlock()
+
+		for txn, rms := range pending {
+			tm.send(txn, rms, decisionMsg(decisions[txn]))
+		}
+	}
+}
+
+// compactLocked rewrites the log with records of the transactions remembered
+func (tm *TM) compactLocked() {
+	if tm.closed || tm.logged < tm.conf.CompactThreshold {
+		return
+	}
+
+	var live []Record
+	for txn, t := range tm.txns {
+		if t.decision == 0 {
+			continue
+		}
+		live = append(live, Record{Type: RecordDecision, Txn: txn, Decision: t.decision, RMs: t.rms})
+		if t.ended && len(t.rms) > 0 {
+			live = append(live, Record{Type: RecordEnd, Txn: txn})
+		}
+	}
+	if tm.logged < 2*len(live) {
+		return
+	}
+	if tm.conf.Log.Compact(live) == nil {
+		tm.logged = len(live)
+	}
+}
@@ -0,0 +1,117 @@
+// Package twopc implements the two-phase commit protocol specified in tla/2pc.tla:
+// the TM commits a transaction iff all RMs have sent Prepared, and the decision is
+// logged before being sent, so that RMs never end up both committed and aborted (TCConsistent).
+//
+// Beyond the spec, the TM sends Prepare to start a transaction, RMs vote no with Aborted,
+// decisions are resent until acknowledged, and in-doubt RMs query the TM after restart.
+// A transaction without a logged decision is presumed aborted.
+// Transactions acknowledged by all RMs are forgotten after a retention period,
+// and the logs are compacted to the transactions remembered.
+package twopc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Decision of a transaction
+type Decision byte
+
+const (
+	// DecisionCommit commits the transaction
+	DecisionCommit Decision = iota + 1
+	// DecisionAbort aborts the transaction
+	DecisionAbort
+)
+
+// String implements fmt.Stringer interface.
+func (d Decision) String() string {
+	switch d {
+	case DecisionCommit:
+		return "commit"
+	case DecisionAbort:
+		return "abort"
+	default:
+		return fmt.Sprintf("invalid decision %d", d)
+	}
+}
+
+// MsgType of Message
+type MsgType byte
+
+const (
+	// MsgPrepare is sent by TM to RMs
+	MsgPrepare MsgType = iota
+	// MsgPrepared is sent by RM to TM as a yes vote
+	MsgPrepared
+	// MsgAborted is sent by RM to TM as a no vote
+	MsgAborted
+	// MsgCommit is sent by TM to RMs
+	MsgCommit
+	// MsgAbort is sent by TM to RMs
+	MsgAbort
+	// MsgAck is sent by RM to TM after applying the decision
+	MsgAck
+	// MsgQuery is sent by an in-doubt RM to TM for the decision
+	MsgQuery
+)
+
+// Message between TM and RMs
+type Message struct {
+	Type MsgType
+	Txn  string
+	From string
+}
+
+// Transport delivers messages, it's asynchronous and may lose, duplicate or reorder messages,
+// the receiver's Handle should be called upon delivery.
+type Transport interface {
+	Send(to string, msg Message)
+}
+
+// RMState is the state of a transaction in RM
+type RMState byte
+
+const (
+	// RMWorking is the initial state
+	RMWorking RMState = iota
+	// RMPrepared means it voted yes
+	RMPrepared
+	// RMCommitted means it committed
+	RMCommitted
+	// RMAborted means it aborted
+	RMAborted
+)
+
+// String implements fmt.Stringer interface.
+func (s RMState) String() string {
+	switch s {
+	case RMWorking:
+		return "working"
+	case RMPrepared:
+		return "prepared"
+	case RMCommitted:
+		return "committed"
+	case RMAborted:
+		return "aborted"
+	default:
+		return fmt.Sprintf("invalid state %d", s)
+	}
+}
+
+var (
+	// ErrClosed when TM or RM is closed
+	ErrClosed = errors.New("closed")
+	// ErrTxnExists when the transaction id is used
+	ErrTxnExists = errors.New("transaction exists")
+	// ErrPrepared when aborting a prepared transaction spontaneously
+	ErrPrepared     = errors.New("transaction prepared")
+	errLogTruncated = errors.New("log truncated")
+)
+
+func decisionMsg(d Decision) MsgType {
+	if d == DecisionCommit {
+		return MsgCommit
+	}
+	return MsgAbort
+}
@@ -0,0 +1,566 @@
+package twopc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// simNet delivers messages after a random delay, and may lose or duplicate them
+type simNet struct {
+	mu       sync.Mutex
+	handlers map[string]func(Message)
+	loss     float64
+	dup      float64
+}
+
+func newSimNet() *simNet {
+	return &simNet{handlers: make(map[string]func(Message))}
+}
+
+func (n *simNet) register(name string, h func(Message)) {
+	n.mu.Lock()
+	n.handlers[name] = h
+	n.mu.Unlock()
+}
+
+func (n *simNet) setFaults(loss, dup float64) {
+	n.mu.Lock()
+	n.loss, n.dup = loss, dup
+	n.mu.Unlock()
+}
+
+func (n *simNet) Send(to string, msg Message) {
+	n.mu.Lock()
+	loss, dup := n.loss, n.dup
+	n.mu.Unlock()
+
+	copies := 1
+	if rand.Float64() < loss {
+		copies = 0
+	} else if rand.Float64() < dup {
+		copies = 2
+	}
+	for i := 0; i < copies; i++ {
+		go func() {
+			time.Sleep(time.Duration(rand.Intn(2000)) * time.Microsecond)
+			n.mu.Lock()
+			h := n.handlers[to]
+			n.mu.Unlock()
+			if h != nil {
+				h(msg)
+			}
+		}()
+	}
+}
+
+// checker records outcomes of RMs, and checks TCConsistent of the spec
+type checker struct {
+	mu        sync.Mutex
+	outcomes  map[string]map[string]RMState
+	violation string
+}
+
+func newChecker() *checker {
+	return &checker{outcomes: make(map[string]map[string]RMState)}
+}
+
+func (c *checker) record(txn, rm string, s RMState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := c.outcomes[txn]
+	if m == nil {
+		m = make(map[string]RMState)
+		c.outcomes[txn] = m
+	}
+	if prev, ok := m[rm]; ok && prev != s && c.violation == "" {
+		c.violation = fmt.Sprintf("%s %s both %v and %v", txn, rm, prev, s)
+	}
+	m[rm] = s
+	for other, os := range m {
+		if os != s && c.violation == "" {
+			c.violation = fmt.Sprintf("%s %s %v but %s %v", txn, rm, s, other, os)
+		}
+	}
+}
+
+func (c *checker) outcome(txn, rm string) (s RMState, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok = c.outcomes[txn][rm]
+	return
+}
+
+type testResource struct {
+	name string
+	c    *checker
+	fail float64
+}
+
+func (r *testResource) Prepare(txn string) error {
+	if rand.Float64() < r.fail {
+		return errors.New("conflict")
+	}
+	return nil
+}
+
+func (r *testResource) Commit(txn string) {
+	r.c.record(txn, r.name, RMCommitted)
+}
+
+func (r *testResource) Abort(txn string) {
+	r.c.record(txn, r.name, RMAborted)
+}
+
+type cluster struct {
+	t      *testing.T
+	net    *simNet
+	c      *checker
+	tmLog  Log
+	rmLogs []Log
+	// for TMConf and RMConf
+	retention        time.Duration
+	compactThreshold int
+
+	mu  sync.Mutex
+	tm  *TM
+	rms []*RM
+}
+
+func newCluster(t *testing.T, total int, retention time.Duration, compactThreshold int) *cluster {
+	cl := &cluster{t: t, net: newSimNet(), c: newChecker(), tmLog: NewMemLog(), rms: make([]*RM, total), retention: retention, compactThreshold: compactThreshold}
+	for i := 0; i < total; i++ {
+		cl.rmLogs = append(cl.rmLogs, NewMemLog())
+	}
+	cl.restartTM()
+	for i := 0; i < total; i++ {
+		cl.restartRM(i)
+	}
+	return cl
+}
+
+func rmName(i int) string {
+	return fmt.Sprint("rm", i)
+}
+
+func (cl *cluster) restartTM() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.tm != nil {
+		cl.tm.Close()
+	}
+	tm, err := NewTM(TMConf{Name: "tm", Log: cl.tmLog, Transport: cl.net, PrepareTimeout: 50 * time.Millisecond, ResendInterval: 10 * time.Millisecond, Retention: cl.retention, CompactThreshold: cl.compactThreshold})
+	if err != nil {
+		cl.t.Fatal(err)
+	}
+	cl.tm = tm
+	cl.net.register("tm", tm.Handle)
+}
+
+func (cl *cluster) restartRM(i int) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.rms[i] != nil {
+		cl.rms[i].Close()
+	}
+	res := &testResource{name: rmName(i), c: cl.c, fail: 0.05}
+	rm, err := NewRM(RMConf{Name: rmName(i), TM: "tm", Log: cl.rmLogs[i], Transport: cl.net, QueryInterval: 20 * time.Millisecond, Retention: cl.retention, CompactThreshold: cl.compactThreshold}, res)
+	if err != nil {
+		cl.t.Fatal(err)
+	}
+	cl.rms[i] = rm
+	cl.net.register(rmName(i), rm.Handle)
+}
+
+func (cl *cluster) currentTM() *TM {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	return cl.tm
+}
+
+func (cl *cluster) close() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.tm.Close()
+	for _, rm := range cl.rms {
+		rm.Close()
+	}
+}
+
+func TestTwoPhaseCommit(t *testing.T) {
+	const (
+		total   = 5
+		clients = 10
+		txns    = 20
+	)
+	// decisions are kept till checked, RM logs are compacted
+	cl := newCluster(t, total, 0, 16)
+	defer cl.close()
+	cl.net.setFaults(0.1, 0.1)
+
+	type result struct {
+		rms      []string
+		decision Decision
+	}
+	var (
+		mu      sync.Mutex
+		results = make(map[string]result)
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < clients; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < txns; j++ {
+				txn := fmt.Sprintf("txn%d_%d", i, j)
+				var rms []string
+				for _, k := range rand.Perm(total)[:2+rand.Intn(total-1)] {
+					rms = append(rms, rmName(k))
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				d, err := cl.currentTM().Commit(ctx, txn, rms)
+				cancel()
+				if err != nil && err != ErrClosed {
+					t.Error(txn, err)
+				}
+				mu.Lock()
+				results[txn] = result{rms: rms, decision: d}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// crash and restart TM and RMs randomly
+	stopCh := make(chan struct{})
+	chaosDone := make(chan struct{})
+	go func() {
+		defer close(chaosDone)
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(time.Duration(10+rand.Intn(20)) * time.Millisecond):
+			}
+			if rand.Intn(total+1) == 0 {
+				cl.restartTM()
+			} else {
+				cl.restartRM(rand.Intn(total))
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(stopCh)
+	<-chaosDone
+
+	// heal the network, all transactions settle
+	cl.net.setFaults(0, 0)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		settled := true
+		cl.mu.Lock()
+		for _, rm := range cl.rms {
+			if rm.InDoubt() > 0 {
+				settled = false
+			}
+		}
+		cl.mu.Unlock()
+		if settled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("in-doubt transactions not resolved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cl.c.mu.Lock()
+	violation := cl.c.violation
+	cl.c.mu.Unlock()
+	if violation != "" {
+		t.Fatal(violation)
+	}
+
+	// RMs follow the decisions returned, committed ones reach all RMs
+	var commits int
+	tm := cl.currentTM()
+	for txn, r := range results {
+		d := r.decision
+		if d == 0 {
+			d = tm.Decision(txn)
+		}
+		for _, rm := range r.rms {
+			s, ok := cl.c.outcome(txn, rm)
+			switch d {
+			case DecisionCommit:
+				if !ok || s != RMCommitted {
+					t.Fatal(txn, rm, s, ok)
+				}
+			default:
+				if ok && s != RMAborted {
+					t.Fatal(txn, rm, s)
+				}
+			}
+		}
+		if d == DecisionCommit {
+			commits++
+		}
+	}
+	if commits == 0 {
+		t.Fatal("nothing committed")
+	}
+}
+
+// failingLog fails Append while fail is set
+type failingLog struct {
+	*MemLog
+	mu   sync.Mutex
+	fail bool
+}
+
+func (l *failingLog) setFail(fail bool) {
+	l.mu.Lock()
+	l.fail = fail
+	l.mu.Unlock()
+}
+
+func (l *failingLog) Append(r Record) error {
+	l.mu.Lock()
+	fail := l.fail
+	l.mu.Unlock()
+	if fail {
+		return errors.New("disk full")
+	}
+	return l.MemLog.Append(r)
+}
+
+func TestDecisionNotLogged(t *testing.T) {
+	net := newSimNet()
+	c := newChecker()
+	tmLog := &failingLog{MemLog: NewMemLog(), fail: true}
+	tm, err := NewTM(TMConf{Name: "tm", Log: tmLog, Transport: net, ResendInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tm.Close()
+	rm, err := NewRM(RMConf{Name: "rm0", TM: "tm", Log: NewMemLog(), Transport: net, QueryInterval: 10 * time.Millisecond}, &testResource{name: "rm0", c: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rm.Close()
+	net.register("tm", tm.Handle)
+	net.register("rm0", rm.Handle)
+
+	d, err := tm.Commit(context.Background(), "t1", []string{"rm0"})
+	if err == nil || d != 0 || tm.Txns() != 0 {
+		t.Fatal(d, err, tm.Txns())
+	}
+	if rm.State("t1") != RMPrepared {
+		t.Fatal(rm.State("t1"))
+	}
+
+	// the prepared RM is answered as presumed aborted once the log recovers
+	tmLog.setFail(false)
+	deadline := time.Now().Add(5 * time.Second)
+	for rm.State("t1") != RMAborted {
+		if time.Now().After(deadline) {
+			t.Fatal(rm.State("t1"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if tm.Decision("t1") != DecisionAbort {
+		t.Fatal(tm.Decision("t1"))
+	}
+}
+
+func TestForget(t *testing.T) {
+	const (
+		total     = 3
+		txns      = 100
+		threshold = 16
+	)
+	cl := newCluster(t, total, 20*time.Millisecond, threshold)
+	defer cl.close()
+
+	rms := []string{rmName(0), rmName(1), rmName(2)}
+	for i := 0; i < txns; i++ {
+		_, err := cl.currentTM().Commit(context.Background(), fmt.Sprint("txn", i), rms)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// all transactions are acknowledged, forgotten, and compacted out of the logs
+	memLen := func(l Log) int {
+		ml := l.(*MemLog)
+		ml.mu.Lock()
+		defer ml.mu.Unlock()
+		return len(ml.records)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cl.mu.Lock()
+		forgotten := cl.tm.Txns() == 0 && memLen(cl.tmLog) < threshold
+		for i, rm := range cl.rms {
+			if rm.Txns() > 0 || memLen(cl.rmLogs[i]) >= threshold {
+				forgotten = false
+			}
+		}
+		cl.mu.Unlock()
+		if forgotten {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("transactions not forgotten")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cl.c.mu.Lock()
+	violation := cl.c.violation
+	cl.c.mu.Unlock()
+	if violation != "" {
+		t.Fatal(violation)
+	}
+
+	// duplicate messages about forgotten transactions are harmless
+	cl.net.register(rmName(0), nil)
+	cl.currentTM().Handle(Message{Type: MsgAck, Txn: "txn0", From: rmName(0)})
+	cl.rms[0].Handle(Message{Type: MsgCommit, Txn: "txn0", From: "tm"})
+	if cl.currentTM().Txns() != 0 || cl.rms[0].Txns() != 0 {
+		t.Fatal(cl.currentTM().Txns(), cl.rms[0].Txns())
+	}
+}
+
+func TestDiskLogCompact(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewDiskLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		err = l.Append(Record{Type: RecordPrepared, Txn: fmt.Sprint("t", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = l.Compact([]Record{{Type: RecordCommitted, Txn: "t8"}, {Type: RecordAborted, Txn: "t9"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Append(Record{Type: RecordPrepared, Txn: "t10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l, err = NewDiskLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var txns []string
+	err = l.Replay(func(r Record) {
+		txns = append(txns, fmt.Sprint(r.Txn, r.Type))
+	})
+	if err != nil || fmt.Sprint(txns) != fmt.Sprint([]string{
+		fmt.Sprint("t8", RecordCommitted), fmt.Sprint("t9", RecordAborted), fmt.Sprint("t10", RecordPrepared)}) {
+		t.Fatal(txns, err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, diskLogGenName(0))); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskLogRecovery(t *testing.T) {
+	dir := t.TempDir()
+	net := newSimNet()
+	c := newChecker()
+
+	open := func() (tm *TM, rm *RM, logs []Log) {
+		tmLog, err := NewDiskLog(dir + "/tm")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rmLog, err := NewDiskLog(dir + "/rm0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tm, err = NewTM(TMConf{Name: "tm", Log: tmLog, Transport: net, ResendInterval: 10 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rm, err = NewRM(RMConf{Name: "rm0", TM: "tm", Log: rmLog, Transport: net, QueryInterval: 10 * time.Millisecond}, &testResource{name: "rm0", c: c})
+		if err != nil {
+			t.Fatal(err)
+		}
+		net.register("tm", tm.Handle)
+		net.register("rm0", rm.Handle)
+		logs = []Log{tmLog, rmLog}
+		return
+	}
+	shutdown := func(tm *TM, rm *RM, logs []Log) {
+		tm.Close()
+		rm.Close()
+		for _, l := range logs {
+			l.Close()
+		}
+	}
+
+	tm, rm, logs := open()
+	d, err := tm.Commit(context.Background(), "t1", []string{"rm0"})
+	if err != nil || d != DecisionCommit {
+		t.Fatal(d, err)
+	}
+	for rm.State("t1") != RMCommitted {
+		time.Sleep(time.Millisecond)
+	}
+
+	// t2 is prepared by rm0 while TM crashes before deciding
+	net.register("tm", nil)
+	rm.Handle(Message{Type: MsgPrepare, Txn: "t2", From: "tm"})
+	if rm.State("t2") != RMPrepared {
+		t.Fatal(rm.State("t2"))
+	}
+	if rm.Abort("t2") != ErrPrepared {
+		t.FailNow()
+	}
+	shutdown(tm, rm, logs)
+
+	tm, rm, logs = open()
+	defer shutdown(tm, rm, logs)
+	if tm.Decision("t1") != DecisionCommit || rm.State("t1") != RMCommitted {
+		t.Fatal(tm.Decision("t1"), rm.State("t1"))
+	}
+
+	// the in-doubt t2 is presumed aborted
+	deadline := time.Now().Add(5 * time.Second)
+	for rm.State("t2") != RMAborted {
+		if time.Now().After(deadline) {
+			t.Fatal(rm.State("t2"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if tm.Decision("t2") != DecisionAbort {
+		t.Fatal(tm.Decision("t2"))
+	}
+	if _, err = tm.Commit(context.Background(), "t2", []string{"rm0"}); err != ErrTxnExists {
+		t.Fatal(err)
+	}
+	if s, _ := c.outcome("t2", "rm0"); s != RMAborted {
+		t.Fatal(s)
+	}
+}
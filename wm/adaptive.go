@@ -0,0 +1,230 @@
+package wm
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	kitmetrics "github.com/go-kit/kit/metrics"
+	"github.com/zhiqiangxu/util/metrics"
+)
+
+// AdaptiveConf for Adaptive
+type AdaptiveConf struct {
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+	// an Exit slower than this is treated like a failure
+	LatencyThreshold time.Duration
+	// the limit is multiplied by Backoff on failure, default 0.9
+	Backoff float64
+	// fraction of the limit available to each priority class, 0 is the most critical,
+	// e.g, {1.2, 1} lets critical requests enter 20% beyond the limit, default {1}
+	PriorityShares []float64
+	// gauges <MetricName>_limit and <MetricName>_inflight are registered if not empty
+	MetricName string
+}
+
+// Adaptive watermark model, an AIMD concurrency limiter:
+// the limit grows by 1 per limit successes, and shrinks by Backoff on failure or high latency.
+type Adaptive struct {
+	conf AdaptiveConf
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	waiters  []list.List // per priority, of *adaptiveWaiter
+
+	limitGauge    kitmetrics.Gauge
+	inflightGauge kitmetrics.Gauge
+}
+
+type adaptiveWaiter struct {
+	ready chan struct{}
+}
+
+// Ticket is returned by Enter and passed to Exit
+type Ticket struct {
+	start time.Time
+}
+
+// NewAdaptive is ctor for Adaptive
+func NewAdaptive(conf AdaptiveConf) *Adaptive {
+	if conf.MinLimit <= 0 {
+		conf.MinLimit = 1
+	}
+	if conf.MaxLimit < conf.MinLimit {
+		panic(fmt.Sprintf("MaxLimit(%d) < MinLimit(%d)", conf.MaxLimit, conf.MinLimit))
+	}
+	if conf.InitialLimit < conf.MinLimit {
+		conf.InitialLimit = conf.MinLimit
+	}
+	if conf.InitialLimit > conf.MaxLimit {
+		conf.InitialLimit = conf.MaxLimit
+	}
+	if conf.Backoff <= 0 || conf.Backoff >= 1 {
+		conf.Backoff = 0.9
+	}
+	if len(conf.PriorityShares) == 0 {
+		conf.PriorityShares = []float64{1}
+	}
+	for _, share := range conf.PriorityShares {
+		if share <= 0 {
+			panic(fmt.Sprintf("invalid priority share %v", share))
+		}
+	}
+
+	a := &Adaptive{conf: conf, limit: float64(conf.InitialLimit), waiters: make([]list.List, len(conf.PriorityShares))}
+	if conf.MetricName != "" {
+		a.limitGauge = metrics.RegisterGauge(conf.MetricName+"_limit", nil)
+		a.inflightGauge = metrics.RegisterGauge(conf.MetricName+"_inflight", nil)
+		a.updateMetricsLocked()
+	}
+	return a
+}
+
+// Enter blocks until admitted for priority or ctx is done
+func (a *Adaptive) Enter(ctx context.Context, priority int) (t Ticket, err error) {
+	a.checkPriority(priority)
+
+	a.mu.Lock()
+	if a.admitLocked(priority) {
+		a.mu.Unlock()
+		t.start = time.Now()
+		return
+	}
+	w := &adaptiveWaiter{ready: make(chan struct{})}
+	elem := a.waiters[priority].PushBack(w)
+	a.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		t.start = time.Now()
+	case <-ctx.Done():
+		a.mu.Lock()
+		select {
+		case <-w.ready:
+			// admitted after canceled, give it back
+			a.inflight--
+			a.notifyWaitersLocked()
+		default:
+			// waiters of lower priorities may be behind it
+			a.waiters[priority].Remove(elem)
+			a.notifyWaitersLocked()
+		}
+		a.updateMetricsLocked()
+		a.mu.Unlock()
+		err = ctx.Err()
+	}
+	return
+}
+
+// TryEnter returns false if not admitted for priority
+func (a *Adaptive) TryEnter(priority int) (t Ticket, ok bool) {
+	a.checkPriority(priority)
+
+	a.mu.Lock()
+	ok = a.admitLocked(priority)
+	a.mu.Unlock()
+
+	if ok {
+		t.start = time.Now()
+	}
+	return
+}
+
+// Exit should only be called if admitted, failed is for errors like timeout or overload,
+// which shrink the limit
+func (a *Adaptive) Exit(t Ticket, failed bool) {
+	latency := time.Since(t.start)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.inflight--
+	if failed || (a.conf.LatencyThreshold > 0 && latency > a.conf.LatencyThreshold) {
+		a.limit *= a.conf.Backoff
+	} else {
+		a.limit += 1 / a.limit
+	}
+	if a.limit < float64(a.conf.MinLimit) {
+		a.limit = float64(a.conf.MinLimit)
+	}
+	if a.limit > float64(a.conf.MaxLimit) {
+		a.limit = float64(a.conf.MaxLimit)
+	}
+
+	a.notifyWaitersLocked()
+	a.updateMetricsLocked()
+}
+
+// Limit returns the current limit
+func (a *Adaptive) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return int(a.limit)
+}
+
+// Inflight returns the number admitted but not exited
+func (a *Adaptive) Inflight() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.inflight
+}
+
+func (a *Adaptive) checkPriority(priority int) {
+	if priority < 0 || priority >= len(a.waiters) {
+		panic(fmt.Sprintf("invalid priority %d", priority))
+	}
+}
+
+// capacityLocked is at least 1, so that every priority can be admitted
+func (a *Adaptive) capacityLocked(priority int) int {
+	capacity := int(a.limit * a.conf.PriorityShares[priority])
+	if capacity < 1 {
+		capacity = 1
+	}
+	return capacity
+}
+
+// admitLocked admits if nobody of the same or higher priority is waiting
+func (a *Adaptive) admitLocked(priority int) bool {
+	for p := 0; p <= priority; p++ {
+		if a.waiters[p].Len() > 0 {
+			return false
+		}
+	}
+	if a.inflight >= a.capacityLocked(priority) {
+		return false
+	}
+	a.inflight++
+	a.updateMetricsLocked()
+	return true
+}
+
+func (a *Adaptive) notifyWaitersLocked() {
+	for p := range a.waiters {
+		waiters := &a.waiters[p]
+		for waiters.Len() > 0 && a.inflight < a.capacityLocked(p) {
+			w := waiters.Remove(waiters.Front()).(*adaptiveWaiter)
+			a.inflight++
+			close(w.ready)
+		}
+		if waiters.Len() > 0 {
+			// lower priorities wait behind
+			return
+		}
+	}
+}
+
+func (a *Adaptive) updateMetricsLocked() {
+	if a.limitGauge == nil {
+		return
+	}
+	a.limitGauge.Set(a.limit)
+	a.inflightGauge.Set(float64(a.inflight))
+}
@@ -0,0 +1,159 @@
+package wm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdaptive(t *testing.T) {
+	a := NewAdaptive(AdaptiveConf{InitialLimit: 4, MinLimit: 2, MaxLimit: 8, MetricName: "test_adaptive"})
+
+	// grows on success
+	for i := 0; i < 100; i++ {
+		ticket, ok := a.TryEnter(0)
+		if !ok {
+			t.FailNow()
+		}
+		a.Exit(ticket, false)
+	}
+	if a.Limit() != 8 {
+		t.Fatal(a.Limit())
+	}
+
+	// shrinks on failure
+	for i := 0; i < 100; i++ {
+		ticket, _ := a.TryEnter(0)
+		a.Exit(ticket, true)
+	}
+	if a.Limit() != 2 || a.Inflight() != 0 {
+		t.Fatal(a.Limit())
+	}
+
+	// blocks at the limit
+	t1, _ := a.TryEnter(0)
+	t2, _ := a.TryEnter(0)
+	if _, ok := a.TryEnter(0); ok {
+		t.FailNow()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if _, err := a.Enter(ctx, 0); err != context.DeadlineExceeded {
+		t.FailNow()
+	}
+
+	enteredCh := make(chan struct{})
+	go func() {
+		ticket, err := a.Enter(context.Background(), 0)
+		if err != nil {
+			t.Error(err)
+		}
+		close(enteredCh)
+		a.Exit(ticket, false)
+	}()
+	time.Sleep(time.Millisecond * 50)
+	a.Exit(t1, false)
+	<-enteredCh
+	a.Exit(t2, false)
+}
+
+func TestAdaptivePriority(t *testing.T) {
+	a := NewAdaptive(AdaptiveConf{InitialLimit: 10, MaxLimit: 10, PriorityShares: []float64{1.5, 1}})
+
+	var tickets []Ticket
+	for i := 0; i < 10; i++ {
+		ticket, ok := a.TryEnter(1)
+		if !ok {
+			t.FailNow()
+		}
+		tickets = append(tickets, ticket)
+	}
+	if _, ok := a.TryEnter(1); ok {
+		t.FailNow()
+	}
+
+	// critical requests still enter beyond the limit
+	for i := 0; i < 5; i++ {
+		ticket, ok := a.TryEnter(0)
+		if !ok {
+			t.FailNow()
+		}
+		tickets = append(tickets, ticket)
+	}
+	if _, ok := a.TryEnter(0); ok {
+		t.FailNow()
+	}
+
+	// a waiting critical request goes first
+	lowCh := make(chan Ticket, 1)
+	go func() {
+		ticket, _ := a.Enter(context.Background(), 1)
+		lowCh <- ticket
+	}()
+	time.Sleep(time.Millisecond * 50)
+	highCh := make(chan Ticket, 1)
+	go func() {
+		ticket, _ := a.Enter(context.Background(), 0)
+		highCh <- ticket
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	a.Exit(tickets[0], false)
+	select {
+	case ticket := <-highCh:
+		a.Exit(ticket, false)
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+	for _, ticket := range tickets[1:] {
+		a.Exit(ticket, false)
+	}
+	a.Exit(<-lowCh, false)
+	if a.Inflight() != 0 {
+		t.FailNow()
+	}
+}
+
+func TestAdaptiveCancel(t *testing.T) {
+	a := NewAdaptive(AdaptiveConf{InitialLimit: 2, MaxLimit: 2, PriorityShares: []float64{0.5, 1}})
+	ticket, ok := a.TryEnter(0)
+	if !ok {
+		t.FailNow()
+	}
+
+	// a lower priority request waits behind a higher one, which then gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	highCh := make(chan error, 1)
+	go func() {
+		_, err := a.Enter(ctx, 0)
+		highCh <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	lowCh := make(chan Ticket, 1)
+	go func() {
+		ticket, _ := a.Enter(context.Background(), 1)
+		lowCh <- ticket
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	cancel()
+	if <-highCh != context.Canceled {
+		t.FailNow()
+	}
+	select {
+	case low := <-lowCh:
+		a.Exit(low, false)
+	case <-time.After(time.Second):
+		t.Fatal("lower priority not admitted")
+	}
+	a.Exit(ticket, false)
+
+	// a tiny share still admits one
+	a = NewAdaptive(AdaptiveConf{InitialLimit: 10, MaxLimit: 10, PriorityShares: []float64{1, 0.05}})
+	if _, ok := a.TryEnter(1); !ok {
+		t.FailNow()
+	}
+	if _, ok := a.TryEnter(1); ok {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,153 @@
+package wm
+
+import (
+	"context"
+	"sync"
+
+	"github.com/zhiqiangxu/rpheap"
+)
+
+// Mark watermark model for items done out of order, e.g, parallel consumers of a stream.
+// The low watermark is the largest id such that all begun ids <= it are done,
+// which is safe to commit. Begin(id) must happen before Done of any larger id.
+type Mark struct {
+	mu        sync.Mutex
+	doneUntil int64
+	high      int64
+	pending   map[int64]int // id -> Begin count minus Done count
+	ids       *rpheap.Heap  // ids in pending
+	waits     map[int64][]chan struct{}
+	waitIDs   *rpheap.Heap // ids in waits
+}
+
+// NewMark is ctor for Mark, doneUntil is the initial low watermark
+func NewMark(doneUntil int64) *Mark {
+	return &Mark{
+		doneUntil: doneUntil,
+		high:      doneUntil,
+		pending:   make(map[int64]int),
+		ids:       rpheap.New(),
+		waits:     make(map[int64][]chan struct{}),
+		waitIDs:   rpheap.New(),
+	}
+}
+
+// Begin an item, the same id can be begun more than once
+func (m *Mark) Begin(id int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id <= m.doneUntil {
+		panic("Begin below low watermark")
+	}
+	if m.pending[id] == 0 {
+		m.ids.Insert(id)
+	}
+	m.pending[id]++
+	if id > m.high {
+		m.high = id
+	}
+}
+
+// Done an item, which advances the low watermark if it's the oldest unfinished one
+func (m *Mark) Done(id int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := m.pending[id]
+	if !ok || n <= 0 {
+		panic("Done without Begin")
+	}
+	m.pending[id] = n - 1
+
+	// ids done are removed lazily from heap
+	for m.ids.Size() > 0 {
+		min := m.ids.FindMin()
+		if m.pending[min] > 0 {
+			break
+		}
+		delete(m.pending, min)
+		m.ids.DeleteMin()
+		m.doneUntil = min
+	}
+
+	for m.waitIDs.Size() > 0 {
+		min := m.waitIDs.FindMin()
+		if min > m.doneUntil {
+			break
+		}
+		for _, w := range m.waits[min] {
+			close(w)
+		}
+		delete(m.waits, min)
+		m.waitIDs.DeleteMin()
+	}
+}
+
+// DoneUntil returns the low watermark
+func (m *Mark) DoneUntil() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.doneUntil
+}
+
+// High returns the largest id ever begun
+func (m *Mark) High() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.high
+}
+
+// Pending returns the number of ids begun but not done
+func (m *Mark) Pending() (n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range m.pending {
+		if c > 0 {
+			n++
+		}
+	}
+	return
+}
+
+// Wait for low watermark >= id
+func (m *Mark) Wait(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	if m.doneUntil >= id {
+		m.mu.Unlock()
+		return nil
+	}
+	waiter := make(chan struct{})
+	ws := m.waits[id]
+	if ws == nil {
+		m.waitIDs.Insert(id)
+	}
+	m.waits[id] = append(ws, waiter)
+	m.mu.Unlock()
+
+	select {
+	case <-waiter:
+		return nil
+	case <-ctx.Done():
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		select {
+		case <-waiter:
+			return nil
+		default:
+		}
+		// the id stays in waitIDs until the low watermark passes it
+		ws := m.waits[id]
+		for i, w := range ws {
+			if w == waiter {
+				m.waits[id] = append(ws[:i], ws[i+1:]...)
+				break
+			}
+		}
+		return ctx.Err()
+	}
+}
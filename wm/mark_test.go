@@ -0,0 +1,91 @@
+package wm
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMark(t *testing.T) {
+	m := NewMark(0)
+	for id := int64(1); id <= 5; id++ {
+		m.Begin(id)
+	}
+
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- m.Wait(context.Background(), 3)
+	}()
+
+	m.Done(2)
+	m.Done(3)
+	if m.DoneUntil() != 0 || m.High() != 5 || m.Pending() != 3 {
+		t.FailNow()
+	}
+	select {
+	case <-waitCh:
+		t.FailNow()
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	m.Done(1)
+	if m.DoneUntil() != 3 {
+		t.FailNow()
+	}
+	if <-waitCh != nil {
+		t.FailNow()
+	}
+
+	// canceled wait
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if m.Wait(ctx, 5) != context.DeadlineExceeded {
+		t.FailNow()
+	}
+
+	m.Done(5)
+	m.Done(4)
+	if m.DoneUntil() != 5 || m.Wait(context.Background(), 5) != nil {
+		t.FailNow()
+	}
+}
+
+func TestMarkConcurrent(t *testing.T) {
+	const n = 10000
+	m := NewMark(0)
+
+	idCh := make(chan int64, 100)
+	go func() {
+		for id := int64(1); id <= n; id++ {
+			m.Begin(id)
+			idCh <- id
+		}
+		close(idCh)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range idCh {
+				if rand.Intn(10) == 0 {
+					time.Sleep(time.Microsecond)
+				}
+				m.Done(id)
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if m.Wait(ctx, n) != nil {
+		t.FailNow()
+	}
+	wg.Wait()
+	if m.DoneUntil() != n || m.Pending() != 0 {
+		t.FailNow()
+	}
+}
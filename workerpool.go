@@ -1,71 +1,287 @@
 package util
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"runtime"
 	"sync"
+)
+
+// QueuePolicy decides what to do when the queue of WorkerPool is full
+type QueuePolicy int
 
-	"github.com/zhiqiangxu/util/logger"
-	"go.uber.org/zap"
+const (
+	// QueueBlock waits until the queue has room
+	QueueBlock QueuePolicy = iota
+	// QueueReject fails with ErrWorkerPoolFull
+	QueueReject
 )
 
+// WorkerPoolConf for WorkerPool
+type WorkerPoolConf struct {
+	Size      int // number of workers, default runtime.NumCPU()
+	QueueSize int // tasks queued beyond idle workers, 0 means a task waits for a free worker
+	Lanes     int // priority lanes, lane 0 runs first, default 1
+	Policy    QueuePolicy
+}
+
+type poolTask struct {
+	run     func()
+	abandon func() // nil if nothing to do when abandoned
+}
+
 // WorkerPool is pool of workers
 type WorkerPool struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	conf     WorkerPoolConf
+	lanes    [][]poolTask
+	queued   int
+	workers  int
+	idle     int
+	closed   bool
 	wg       sync.WaitGroup
-	doneChan chan struct{}
-	workChan chan func()
-	once     sync.Once
 }
 
-// NewWorkerPool is ctor for WorkerPool
+var (
+	// ErrWorkerPoolClosed when run on closed pool
+	ErrWorkerPoolClosed = errors.New("workerPool closed")
+	// ErrWorkerPoolFull when the queue is full with QueueReject
+	ErrWorkerPoolFull = errors.New("workerPool full")
+)
+
+// NewWorkerPool is ctor for WorkerPool with runtime.NumCPU() workers
 func NewWorkerPool() *WorkerPool {
-	wp := &WorkerPool{doneChan: make(chan struct{}), workChan: make(chan func())}
+	return NewWorkerPoolWithConf(WorkerPoolConf{})
+}
+
+// NewWorkerPoolWithConf is ctor for WorkerPool with conf
+func NewWorkerPoolWithConf(conf WorkerPoolConf) *WorkerPool {
+	if conf.Size <= 0 {
+		conf.Size = runtime.NumCPU()
+	}
+	if conf.Lanes <= 0 {
+		conf.Lanes = 1
+	}
+	wp := &WorkerPool{conf: conf, lanes: make([][]poolTask, conf.Lanes)}
+	wp.notEmpty = sync.NewCond(&wp.mu)
+	wp.notFull = sync.NewCond(&wp.mu)
 	wp.Start()
 	return wp
 }
 
-// Start worker pool
+// Start workers up to the configured size
 func (wp *WorkerPool) Start() {
-	n := runtime.NumCPU()
-	for i := 0; i < n; i++ {
-		GoFunc(&wp.wg, func() {
-			defer func() {
-				err := recover()
-				if err != nil {
-					logger.Instance().Error("workerPool", zap.Any("err", err))
-				}
-			}()
-			for {
-				select {
-				case f := <-wp.workChan:
-					f()
-				case <-wp.doneChan:
-					return
-				}
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	wp.startLocked()
+}
+
+func (wp *WorkerPool) startLocked() {
+	for wp.workers < wp.conf.Size {
+		wp.workers++
+		GoFunc(&wp.wg, wp.work)
+	}
+}
+
+func (wp *WorkerPool) work() {
+	for {
+		wp.mu.Lock()
+		for wp.queued == 0 && !wp.closed && wp.workers <= wp.conf.Size {
+			wp.idle++
+			wp.notFull.Broadcast()
+			wp.notEmpty.Wait()
+			wp.idle--
+		}
+		if wp.workers > wp.conf.Size || (wp.queued == 0 && wp.closed) {
+			// shrunk or closed
+			wp.workers--
+			wp.mu.Unlock()
+			return
+		}
+
+		var task poolTask
+		for i, lane := range wp.lanes {
+			if len(lane) > 0 {
+				task = lane[0]
+				lane[0] = poolTask{}
+				wp.lanes[i] = lane[1:]
+				break
 			}
-		})
+		}
+		wp.queued--
+		wp.notFull.Signal()
+		wp.mu.Unlock()
+
+		// a panicking task doesn't kill the worker
+		RunWithRecovery(task.run, nil)
+	}
+}
+
+// Resize changes the number of workers, excess workers exit after their current task
+func (wp *WorkerPool) Resize(size int) {
+	if size <= 0 {
+		panic("size <= 0")
 	}
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	if wp.closed {
+		return
+	}
+	wp.conf.Size = size
+	wp.startLocked()
+	wp.notEmpty.Broadcast()
+}
+
+// Size returns the configured number of workers
+func (wp *WorkerPool) Size() int {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	return wp.conf.Size
+}
+
+// Queued returns the number of tasks waiting for workers
+func (wp *WorkerPool) Queued() int {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	return wp.queued
 }
 
-// Close the worker pool
+// Close the worker pool after queued tasks are done
 func (wp *WorkerPool) Close() {
-	wp.once.Do(func() {
-		close(wp.doneChan)
-		wp.wg.Wait()
-	})
+	wp.mu.Lock()
+	wp.closed = true
+	wp.notEmpty.Broadcast()
+	wp.notFull.Broadcast()
+	wp.mu.Unlock()
+
+	wp.wg.Wait()
 }
 
-var (
-	// ErrWorkerPoolClosed when run on closed pool
-	ErrWorkerPoolClosed = errors.New("workerPool closed")
-)
+// CloseAbandon closes the worker pool without running queued tasks,
+// futures of abandoned tasks fail with ErrWorkerPoolClosed.
+func (wp *WorkerPool) CloseAbandon() (abandoned int) {
+	wp.mu.Lock()
+	wp.closed = true
+	var tasks []poolTask
+	for i, lane := range wp.lanes {
+		tasks = append(tasks, lane...)
+		wp.lanes[i] = nil
+	}
+	wp.queued = 0
+	wp.notEmpty.Broadcast()
+	wp.notFull.Broadcast()
+	wp.mu.Unlock()
+
+	for _, task := range tasks {
+		if task.abandon != nil {
+			task.abandon()
+		}
+	}
+	wp.wg.Wait()
+	abandoned = len(tasks)
+	return
+}
 
-// Run a task
+// Run a task in lane 0
 func (wp *WorkerPool) Run(f func()) error {
+	return wp.RunPriority(0, f)
+}
+
+// RunPriority runs a task in lane, which is subject to the queue policy
+func (wp *WorkerPool) RunPriority(lane int, f func()) error {
+	return wp.runTask(lane, poolTask{run: f})
+}
+
+func (wp *WorkerPool) runTask(lane int, task poolTask) error {
+	if lane < 0 || lane >= len(wp.lanes) {
+		return fmt.Errorf("invalid lane %d", lane)
+	}
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	for {
+		if wp.closed {
+			return ErrWorkerPoolClosed
+		}
+		if wp.queued < wp.conf.QueueSize+wp.idle {
+			break
+		}
+		if wp.conf.Policy == QueueReject {
+			return ErrWorkerPoolFull
+		}
+		wp.notFull.Wait()
+	}
+
+	wp.lanes[lane] = append(wp.lanes[lane], task)
+	wp.queued++
+	wp.notEmpty.Signal()
+	return nil
+}
+
+// Future is the result of a task submitted by Submit
+type Future[R any] struct {
+	done chan struct{}
+	once sync.Once
+	r    R
+	err  error
+}
+
+// Done is closed when the result is ready
+func (f *Future[R]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Get waits for the result, a panicking task fails with error
+func (f *Future[R]) Get(ctx context.Context) (r R, err error) {
 	select {
-	case wp.workChan <- f:
-		return nil
-	case <-wp.doneChan:
-		return ErrWorkerPoolClosed
+	case <-f.done:
+		r, err = f.r, f.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+func (f *Future[R]) complete(r R, err error) {
+	f.once.Do(func() {
+		f.r, f.err = r, err
+		close(f.done)
+	})
+}
+
+// Submit runs f in lane of wp and returns its future
+func Submit[R any](wp *WorkerPool, lane int, f func() (R, error)) (*Future[R], error) {
+	future := &Future[R]{done: make(chan struct{})}
+	task := func() {
+		var (
+			r   R
+			err error
+		)
+		RunWithRecovery(func() {
+			r, err = f()
+		}, func(p interface{}) {
+			err = fmt.Errorf("task panic: %v", p)
+		})
+		future.complete(r, err)
+	}
+
+	abandon := func() {
+		var zero R
+		future.complete(zero, ErrWorkerPoolClosed)
+	}
+
+	err := wp.runTask(lane, poolTask{run: task, abandon: abandon})
+	if err != nil {
+		return nil, err
 	}
+	return future, nil
 }
@@ -0,0 +1,140 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	wp := NewWorkerPoolWithConf(WorkerPoolConf{Size: 2})
+
+	// a panicking task doesn't kill the worker
+	for i := 0; i < 4; i++ {
+		future, err := Submit(wp, 0, func() (int, error) {
+			panic("boom")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = future.Get(context.Background()); err == nil {
+			t.FailNow()
+		}
+	}
+
+	future, err := Submit(wp, 0, func() (int, error) { return 1, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := future.Get(context.Background()); err != nil || r != 1 {
+		t.FailNow()
+	}
+
+	wp.Close()
+	if wp.Run(func() {}) != ErrWorkerPoolClosed {
+		t.FailNow()
+	}
+}
+
+func TestWorkerPoolQueue(t *testing.T) {
+	wp := NewWorkerPoolWithConf(WorkerPoolConf{Size: 1, QueueSize: 2, Lanes: 2, Policy: QueueReject})
+
+	blockCh := make(chan struct{})
+	startedCh := make(chan struct{})
+	wp.Run(func() {
+		close(startedCh)
+		<-blockCh
+	})
+	<-startedCh
+
+	// lane 1 is queued first, but lane 0 runs first
+	var (
+		mu    sync.Mutex
+		order []int
+	)
+	for _, lane := range []int{1, 0} {
+		lane := lane
+		if err := wp.RunPriority(lane, func() {
+			mu.Lock()
+			order = append(order, lane)
+			mu.Unlock()
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if wp.Run(func() {}) != ErrWorkerPoolFull || wp.Queued() != 2 {
+		t.FailNow()
+	}
+
+	close(blockCh)
+	wp.Close()
+	if len(order) != 2 || order[0] != 0 {
+		t.Fatal(order)
+	}
+
+	// abandon queued tasks
+	wp = NewWorkerPoolWithConf(WorkerPoolConf{Size: 1, QueueSize: 10})
+	blockCh = make(chan struct{})
+	startedCh = make(chan struct{})
+	wp.Run(func() {
+		close(startedCh)
+		<-blockCh
+	})
+	<-startedCh
+	future, _ := Submit(wp, 0, func() (int, error) { return 0, nil })
+	time.AfterFunc(time.Millisecond*100, func() {
+		close(blockCh)
+	})
+	if wp.CloseAbandon() != 1 {
+		t.FailNow()
+	}
+	if _, err := future.Get(context.Background()); err != ErrWorkerPoolClosed {
+		t.FailNow()
+	}
+}
+
+func TestWorkerPoolResize(t *testing.T) {
+	wp := NewWorkerPoolWithConf(WorkerPoolConf{Size: 1})
+
+	var running, maxRunning int32
+	task := func() {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * 20)
+		atomic.AddInt32(&running, -1)
+	}
+
+	// blocks until a worker is free
+	for i := 0; i < 5; i++ {
+		wp.Run(task)
+	}
+	if atomic.LoadInt32(&maxRunning) != 1 {
+		t.FailNow()
+	}
+
+	wp.Resize(4)
+	for i := 0; i < 20; i++ {
+		wp.Run(task)
+	}
+	if m := atomic.LoadInt32(&maxRunning); m < 2 || m > 4 {
+		t.Fatal(m)
+	}
+
+	wp.Resize(1)
+	time.Sleep(time.Millisecond * 100)
+	atomic.StoreInt32(&maxRunning, 0)
+	for i := 0; i < 5; i++ {
+		wp.Run(task)
+	}
+	wp.Close()
+	if atomic.LoadInt32(&maxRunning) != 1 {
+		t.Fatal(maxRunning)
+	}
+}
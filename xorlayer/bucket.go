@@ -2,43 +2,54 @@ package xorlayer
 
 import (
 	"container/list"
-
-	"github.com/zhiqiangxu/util/sort"
+	"sort"
 )
 
-type bucket struct {
-	m map[NodeID]*list.Element
+type bucket[T ID[T]] struct {
+	m map[T]*list.Element
 	l *list.List
+	// replacement cache, most recent at front
+	rm      map[T]*list.Element
+	rl      *list.List
+	pinging bool // whether the oldest is being pinged
 }
 
-type nodeWithCookie struct {
-	N      NodeID
+type nodeWithCookie[T any] struct {
+	N      T
 	Cookie uint64
+	// consecutive failed rpcs, reset once heard from
+	failures int
 }
 
-func newBucket() *bucket {
-	return &bucket{m: make(map[NodeID]*list.Element), l: list.New()}
+func newBucket[T ID[T]]() *bucket[T] {
+	return &bucket[T]{m: make(map[T]*list.Element), l: list.New(), rm: make(map[T]*list.Element), rl: list.New()}
 }
 
-func (b *bucket) insert(n NodeID, cookie uint64) {
+func (b *bucket[T]) insert(n T, cookie uint64) {
 	e := b.m[n]
 	if e != nil {
-		e.Value.(*nodeWithCookie).Cookie = cookie
+		nwc := e.Value.(*nodeWithCookie[T])
+		nwc.Cookie = cookie
+		nwc.failures = 0
 		b.l.MoveToFront(e)
 	} else {
-		nwc := &nodeWithCookie{
+		nwc := &nodeWithCookie[T]{
 			N:      n,
 			Cookie: cookie,
 		}
 		e = b.l.PushFront(nwc)
 		b.m[n] = e
+		if e := b.rm[n]; e != nil {
+			b.rl.Remove(e)
+			delete(b.rm, n)
+		}
 	}
 }
 
-func (b *bucket) remove(n NodeID, cookie uint64) (removed bool) {
+func (b *bucket[T]) remove(n T, cookie uint64) (removed bool) {
 	e := b.m[n]
 	if e != nil {
-		if e.Value.(*nodeWithCookie).Cookie != cookie {
+		if e.Value.(*nodeWithCookie[T]).Cookie != cookie {
 			return
 		}
 		removed = true
@@ -48,65 +59,108 @@ func (b *bucket) remove(n NodeID, cookie uint64) (removed bool) {
 	return
 }
 
-func (b *bucket) reduceTo(max int) {
+func (b *bucket[T]) addReplacement(n T, cookie uint64, max int) {
+	e := b.rm[n]
+	if e != nil {
+		e.Value.(*nodeWithCookie[T]).Cookie = cookie
+		b.rl.MoveToFront(e)
+		return
+	}
+
+	b.rm[n] = b.rl.PushFront(&nodeWithCookie[T]{N: n, Cookie: cookie})
+	for b.rl.Len() > max {
+		nwc := b.rl.Remove(b.rl.Back()).(*nodeWithCookie[T])
+		delete(b.rm, nwc.N)
+	}
+}
+
+func (b *bucket[T]) removeReplacement(n T, cookie uint64) {
+	e := b.rm[n]
+	if e != nil && e.Value.(*nodeWithCookie[T]).Cookie == cookie {
+		b.rl.Remove(e)
+		delete(b.rm, n)
+	}
+}
+
+// popReplacement pops the most recent one from the replacement cache
+func (b *bucket[T]) popReplacement() (nwc nodeWithCookie[T], ok bool) {
+	e := b.rl.Front()
+	if e == nil {
+		return
+	}
+	nwc = *b.rl.Remove(e).(*nodeWithCookie[T])
+	delete(b.rm, nwc.N)
+	ok = true
+	return
+}
+
+func (b *bucket[T]) reduceTo(max int) {
 	for b.size() > max {
 		e := b.l.Back()
-		nwc := e.Value.(*nodeWithCookie)
+		nwc := e.Value.(*nodeWithCookie[T])
 		delete(b.m, nwc.N)
 		b.l.Remove(e)
 	}
 }
 
-func (b *bucket) refresh(n NodeID) (exists bool) {
+func (b *bucket[T]) refresh(n T) (exists bool) {
 	e := b.m[n]
 	if e != nil {
+		e.Value.(*nodeWithCookie[T]).failures = 0
 		b.l.MoveToFront(e)
 		exists = true
 	}
 	return
 }
 
-func (b *bucket) size() int {
+// fail counts a failed rpc to n, evict is true once max consecutive ones failed
+func (b *bucket[T]) fail(n T, max int) (cookie uint64, evict bool) {
+	e := b.m[n]
+	if e == nil {
+		return
+	}
+	nwc := e.Value.(*nodeWithCookie[T])
+	nwc.failures++
+	cookie, evict = nwc.Cookie, nwc.failures >= max
+	return
+}
+
+func (b *bucket[T]) size() int {
 	return len(b.m)
 }
 
-func (b *bucket) appendXClosest(r []NodeID, x int, target NodeID) []NodeID {
+func (b *bucket[T]) appendXClosest(r []T, x int, target T) []T {
 	if b.size() <= x {
 		return b.appendAll(r)
 	}
 
 	// find k closest NodeID to target
-	all := make([]NodeID, 0, b.size())
+	all := make([]T, 0, b.size())
 	for n := range b.m {
 		all = append(all, n)
 	}
 
-	kclosest := sort.KSmallest(all, x, func(j, k int) int {
-		dj := all[j] ^ target
-		dk := all[k] ^ target
-		switch {
-		case dj < dk:
-			return -1
-		case dj > dk:
-			return 1
-		}
-
-		return 0
-	}).([]NodeID)
-
-	for _, n := range kclosest {
-		r = append(r, n)
-	}
-	return r
+	return append(r, xClosest(all, x, target)...)
 }
 
-func (b *bucket) appendAll(r []NodeID) []NodeID {
+func (b *bucket[T]) appendAll(r []T) []T {
 	for n := range b.m {
 		r = append(r, n)
 	}
 	return r
 }
 
-func (b *bucket) oldest() nodeWithCookie {
-	return *b.l.Back().Value.(*nodeWithCookie)
+func (b *bucket[T]) oldest() nodeWithCookie[T] {
+	return *b.l.Back().Value.(*nodeWithCookie[T])
+}
+
+// xClosest returns the x closest to target in ns, sorted by distance, mutates ns
+func xClosest[T ID[T]](ns []T, x int, target T) []T {
+	sort.Slice(ns, func(i, j int) bool {
+		return closer(ns[i], ns[j], target)
+	})
+	if len(ns) > x {
+		ns = ns[:x]
+	}
+	return ns
 }
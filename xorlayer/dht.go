@@ -0,0 +1,311 @@
+package xorlayer
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// DHTConf for DHT
+type DHTConf[T ID[T]] struct {
+	ID T
+	// K and H of XTM, K is also the replication factor, default 20 and 0
+	K, H int
+	// parallelism of lookup, default 3
+	Alpha int
+	// consecutive failed rpcs before a node is evicted from the routing table, default 3
+	MaxFailures int
+	XTM         XTMConf
+}
+
+// DHT is a kademlia node on top of XTM
+type DHT[T ID[T]] struct {
+	conf DHTConf[T]
+	xtm  *XTM[T]
+	tr   Transport[T]
+
+	mu     sync.RWMutex
+	values map[T][]byte
+}
+
+var (
+	// ErrNotFound when the value is not found
+	ErrNotFound = errors.New("value not found")
+	// ErrNoPeer when no other node responds
+	ErrNoPeer = errors.New("no peer responds")
+)
+
+// NewDHT is ctor for DHT
+func NewDHT[T ID[T]](conf DHTConf[T], tr Transport[T]) *DHT[T] {
+	if conf.K <= 0 {
+		conf.K = 20
+	}
+	if conf.H < 0 {
+		conf.H = 0
+	}
+	if conf.Alpha <= 0 {
+		conf.Alpha = 3
+	}
+	if conf.MaxFailures <= 0 {
+		conf.MaxFailures = 3
+	}
+
+	return &DHT[T]{conf: conf, xtm: NewXTMWithConf[T](conf.K, conf.H, conf.ID, tr, conf.XTM), tr: tr, values: make(map[T][]byte)}
+}
+
+// ID of the node
+func (d *DHT[T]) ID() T {
+	return d.conf.ID
+}
+
+// Close the node
+func (d *DHT[T]) Close() {
+	d.xtm.Close()
+}
+
+// XTM returns the routing table
+func (d *DHT[T]) XTM() *XTM[T] {
+	return d.xtm
+}
+
+// Bootstrap joins the network via seeds by looking up itself
+func (d *DHT[T]) Bootstrap(ctx context.Context, seeds []T) (err error) {
+	for _, seed := range seeds {
+		if seed != d.conf.ID {
+			d.xtm.AddNeighbour(seed, 0)
+		}
+	}
+
+	closest, err := d.Lookup(ctx, d.conf.ID)
+	if err != nil {
+		return
+	}
+	if len(closest) < 2 {
+		// only itself
+		err = ErrNoPeer
+		return
+	}
+
+	err = d.Refresh(ctx)
+	return
+}
+
+// Refresh looks up a random id in each bucket farther than the closest neighbour,
+// so that the routing table knows about nodes joined later
+func (d *DHT[T]) Refresh(ctx context.Context) (err error) {
+	nearest := d.conf.ID.BitSize()
+	for _, n := range d.xtm.KClosest(d.conf.ID) {
+		if n != d.conf.ID {
+			nearest = d.xtm.getBucketIdx(n)
+			break
+		}
+	}
+
+	for i := 0; i < nearest; i++ {
+		_, err = d.Lookup(ctx, d.conf.ID.RandInBucket(i))
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Lookup returns the k closest nodes to target by iterative FIND_NODE, itself included if it's one of them
+func (d *DHT[T]) Lookup(ctx context.Context, target T) (closest []T, err error) {
+	closest, _, err = d.iterate(ctx, target, false)
+	return
+}
+
+// Put stores value on the k closest nodes to key, itself included if it's one of them
+func (d *DHT[T]) Put(ctx context.Context, key T, value []byte) (err error) {
+	closest, err := d.Lookup(ctx, key)
+	if err != nil {
+		return
+	}
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		stored int
+	)
+	for _, n := range closest {
+		if n == d.conf.ID {
+			d.HandleStore(n, key, value)
+			mu.Lock()
+			stored++
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(n T) {
+			defer wg.Done()
+			if d.tr.Store(ctx, n, key, value) == nil {
+				mu.Lock()
+				stored++
+				mu.Unlock()
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	if stored == 0 {
+		err = ErrNoPeer
+	}
+	return
+}
+
+// Get the value of key, locally or by iterative FIND_VALUE
+func (d *DHT[T]) Get(ctx context.Context, key T) (value []byte, err error) {
+	d.mu.RLock()
+	value = d.values[key]
+	d.mu.RUnlock()
+	if value != nil {
+		return
+	}
+
+	_, value, err = d.iterate(ctx, key, true)
+	if err == nil && value == nil {
+		err = ErrNotFound
+	}
+	return
+}
+
+// HandlePing is called by Transport on PING from a remote node
+func (d *DHT[T]) HandlePing(from T) {
+	d.seen(from)
+}
+
+// HandleFindNode is called by Transport on FIND_NODE from a remote node
+func (d *DHT[T]) HandleFindNode(from, target T) []T {
+	d.seen(from)
+	return d.xtm.KClosest(target)
+}
+
+// HandleFindValue is called by Transport on FIND_VALUE from a remote node
+func (d *DHT[T]) HandleFindValue(from, key T) (value []byte, closest []T) {
+	d.seen(from)
+
+	d.mu.RLock()
+	value = d.values[key]
+	d.mu.RUnlock()
+	if value != nil {
+		return
+	}
+
+	closest = d.xtm.KClosest(key)
+	return
+}
+
+// HandleStore is called by Transport on STORE from a remote node
+func (d *DHT[T]) HandleStore(from, key T, value []byte) {
+	d.seen(from)
+
+	d.mu.Lock()
+	d.values[key] = value
+	d.mu.Unlock()
+}
+
+func (d *DHT[T]) seen(from T) {
+	if from != d.conf.ID {
+		d.xtm.AddNeighbour(from, 0)
+	}
+}
+
+type lookupCandidate[T any] struct {
+	id              T
+	queried, failed bool
+}
+
+type lookupReply[T any] struct {
+	c       *lookupCandidate[T]
+	value   []byte
+	closest []T
+	err     error
+}
+
+// iterate keeps alpha queries in flight to the k closest candidates not queried yet,
+// until the k closest that responded are all queried, or the value is found.
+func (d *DHT[T]) iterate(ctx context.Context, target T, findValue bool) (closest []T, value []byte, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// itself counts as queried
+	shortlist := []*lookupCandidate[T]{{id: d.conf.ID, queried: true}}
+	seen := map[T]bool{d.conf.ID: true}
+	add := func(ns []T) {
+		for _, n := range ns {
+			if !seen[n] {
+				seen[n] = true
+				shortlist = append(shortlist, &lookupCandidate[T]{id: n})
+			}
+		}
+		sort.Slice(shortlist, func(i, j int) bool {
+			return closer(shortlist[i].id, shortlist[j].id, target)
+		})
+	}
+	add(d.xtm.KClosest(target))
+
+	replyCh := make(chan lookupReply[T], d.conf.Alpha)
+	query := func(c *lookupCandidate[T]) {
+		rep := lookupReply[T]{c: c}
+		if findValue {
+			rep.value, rep.closest, rep.err = d.tr.FindValue(ctx, c.id, target)
+		} else {
+			rep.closest, rep.err = d.tr.FindNode(ctx, c.id, target)
+		}
+		replyCh <- rep
+	}
+
+	inflight := 0
+	for {
+		active := 0
+		for _, c := range shortlist {
+			if inflight >= d.conf.Alpha || active >= d.conf.K {
+				break
+			}
+			if c.failed {
+				continue
+			}
+			active++
+			if !c.queried {
+				c.queried = true
+				inflight++
+				go query(c)
+			}
+		}
+		if inflight == 0 {
+			break
+		}
+
+		var rep lookupReply[T]
+		select {
+		case rep = <-replyCh:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+		inflight--
+
+		if rep.err != nil {
+			rep.c.failed = true
+			d.xtm.failNeighbour(rep.c.id, d.conf.MaxFailures)
+			continue
+		}
+		d.xtm.AddNeighbour(rep.c.id, 0)
+		if findValue && rep.value != nil {
+			value = rep.value
+			return
+		}
+		add(rep.closest)
+	}
+
+	for _, c := range shortlist {
+		if len(closest) == d.conf.K {
+			break
+		}
+		if !c.failed {
+			closest = append(closest, c.id)
+		}
+	}
+	return
+}
@@ -0,0 +1,137 @@
+package xorlayer
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestDHT(t *testing.T) {
+	const (
+		total = 1000
+		k     = 8
+	)
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(1))
+
+	net := NewSimNetwork[NodeID]()
+	var (
+		ids   []NodeID
+		nodes []*DHT[NodeID]
+	)
+	used := make(map[NodeID]bool)
+	for len(nodes) < total {
+		id := NodeID(r.Uint64())
+		if used[id] {
+			continue
+		}
+		used[id] = true
+
+		d := net.NewDHT(DHTConf[NodeID]{ID: id, K: k, H: 2})
+		if len(nodes) > 0 {
+			err := d.Bootstrap(ctx, []NodeID{ids[r.Intn(len(ids))]})
+			assert.NilError(t, err)
+		}
+		ids = append(ids, id)
+		nodes = append(nodes, d)
+	}
+	defer func() {
+		for _, d := range nodes {
+			d.Close()
+		}
+	}()
+
+	// lookups converge to the true k closest
+	exact := 0
+	const lookups = 200
+	for i := 0; i < lookups; i++ {
+		target := NodeID(r.Uint64())
+		closest, err := nodes[r.Intn(total)].Lookup(ctx, target)
+		assert.NilError(t, err)
+		assert.Equal(t, len(closest), k)
+
+		expected := xClosest(append([]NodeID(nil), ids...), k, target)
+		if reflect.DeepEqual(closest, expected) {
+			exact++
+		}
+	}
+	assert.Assert(t, exact >= lookups*95/100, "exact:%d", exact)
+
+	// values survive some nodes going down
+	keys := make([]NodeID, 100)
+	for i := range keys {
+		keys[i] = NodeID(r.Uint64())
+		err := nodes[r.Intn(total)].Put(ctx, keys[i], []byte(fmt.Sprint(i)))
+		assert.NilError(t, err)
+	}
+	for _, i := range r.Perm(total)[:total/10] {
+		net.SetDown(ids[i], true)
+	}
+	for i, key := range keys {
+		for {
+			d := nodes[r.Intn(total)]
+			if _, err := net.get(d.ID()); err != nil {
+				continue
+			}
+			v, err := d.Get(ctx, key)
+			assert.NilError(t, err)
+			assert.Equal(t, string(v), fmt.Sprint(i))
+			break
+		}
+	}
+
+	_, err := nodes[0].Get(ctx, NodeID(r.Uint64()))
+	assert.Equal(t, err, ErrNotFound)
+}
+
+func TestDHTFailures(t *testing.T) {
+	ctx := context.Background()
+	net := NewSimNetwork[NodeID]()
+	var nodes []*DHT[NodeID]
+	for i := 1; i <= 10; i++ {
+		d := net.NewDHT(DHTConf[NodeID]{ID: NodeID(i), K: 8, MaxFailures: 2})
+		if len(nodes) > 0 {
+			assert.NilError(t, d.Bootstrap(ctx, []NodeID{nodes[0].ID()}))
+		}
+		nodes = append(nodes, d)
+	}
+	defer func() {
+		for _, d := range nodes {
+			d.Close()
+		}
+	}()
+
+	d, victim := nodes[0], nodes[9].ID()
+	known := func() bool {
+		for _, n := range d.XTM().KClosest(victim) {
+			if n == victim {
+				return true
+			}
+		}
+		return false
+	}
+	assert.Assert(t, known())
+
+	// a single failure doesn't evict
+	net.SetDown(victim, true)
+	_, err := d.Lookup(ctx, victim)
+	assert.NilError(t, err)
+	assert.Assert(t, known())
+
+	// success resets the count
+	net.SetDown(victim, false)
+	_, err = d.Lookup(ctx, victim)
+	assert.NilError(t, err)
+	net.SetDown(victim, true)
+	_, err = d.Lookup(ctx, victim)
+	assert.NilError(t, err)
+	assert.Assert(t, known())
+
+	_, err = d.Lookup(ctx, victim)
+	assert.NilError(t, err)
+	assert.Assert(t, !known())
+}
@@ -0,0 +1,124 @@
+package xorlayer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"unsafe"
+)
+
+// ID is the constraint of node ids, ids are compared as unsigned big endian integers
+type ID[T any] interface {
+	comparable
+	// Xor returns the xor distance to o
+	Xor(o T) T
+	Less(o T) bool
+	LeadingZeros() int
+	BitSize() int
+	// RandInBucket returns a random id that shares the first i bits and differs at bit i
+	RandInBucket(i int) T
+}
+
+// NodeID can be replace by https://github.com/zhiqiangxu/gg
+type NodeID uint64
+
+const (
+	bitSize = int(unsafe.Sizeof(NodeID(0)) * 8)
+)
+
+// Xor implements ID
+func (n NodeID) Xor(o NodeID) NodeID {
+	return n ^ o
+}
+
+// Less implements ID
+func (n NodeID) Less(o NodeID) bool {
+	return n < o
+}
+
+// LeadingZeros implements ID
+func (n NodeID) LeadingZeros() int {
+	return bits.LeadingZeros64(uint64(n))
+}
+
+// BitSize implements ID
+func (n NodeID) BitSize() int {
+	return bitSize
+}
+
+// RandInBucket implements ID
+func (n NodeID) RandInBucket(i int) NodeID {
+	bit := uint64(1) << (bitSize - 1 - i)
+	return n ^ NodeID(bit) ^ NodeID(rand.Uint64()&(bit-1))
+}
+
+// NodeID256 is for 256 bit ids like sha256 hashes, encoded in hex as text
+type NodeID256 [32]byte
+
+// Xor implements ID
+func (n NodeID256) Xor(o NodeID256) (r NodeID256) {
+	for i := range n {
+		r[i] = n[i] ^ o[i]
+	}
+	return
+}
+
+// Less implements ID
+func (n NodeID256) Less(o NodeID256) bool {
+	return bytes.Compare(n[:], o[:]) < 0
+}
+
+// LeadingZeros implements ID
+func (n NodeID256) LeadingZeros() int {
+	for i, b := range n {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return len(n) * 8
+}
+
+// BitSize implements ID
+func (n NodeID256) BitSize() int {
+	return len(n) * 8
+}
+
+// RandInBucket implements ID
+func (n NodeID256) RandInBucket(i int) (r NodeID256) {
+	rand.Read(r[:])
+
+	// keep the first i bits of n and flip bit i
+	byteIdx, bitIdx := i/8, uint(i%8)
+	copy(r[:byteIdx], n[:byteIdx])
+	mask := byte(0xff) << (8 - bitIdx)
+	r[byteIdx] = (n[byteIdx] & mask) | (r[byteIdx] &^ mask)
+	r[byteIdx] = (r[byteIdx] &^ (0x80 >> bitIdx)) | (^n[byteIdx] & (0x80 >> bitIdx))
+	return
+}
+
+// String in hex
+func (n NodeID256) String() string {
+	return hex.EncodeToString(n[:])
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (n NodeID256) MarshalText() ([]byte, error) {
+	return []byte(n.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (n *NodeID256) UnmarshalText(text []byte) (err error) {
+	if hex.DecodedLen(len(text)) != len(n) {
+		err = fmt.Errorf("invalid NodeID256 length %d", len(text))
+		return
+	}
+	_, err = hex.Decode(n[:], text)
+	return
+}
+
+// closer returns whether a is closer to target than b
+func closer[T ID[T]](a, b, target T) bool {
+	return a.Xor(target).Less(b.Xor(target))
+}
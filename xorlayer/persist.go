@@ -0,0 +1,113 @@
+package xorlayer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrIDMismatch when loading the routing table of another id
+var ErrIDMismatch = errors.New("routing table of another id")
+
+type xtmSnapshot[T any] struct {
+	ID      T
+	Buckets [][]nodeWithCookie[T] // oldest first
+}
+
+// Save the routing table to w as json
+func (x *XTM[T]) Save(w io.Writer) error {
+	x.RLock()
+	s := xtmSnapshot[T]{ID: x.id, Buckets: make([][]nodeWithCookie[T], len(x.buckets))}
+	for i, bucket := range x.buckets {
+		for e := bucket.l.Back(); e != nil; e = e.Prev() {
+			s.Buckets[i] = append(s.Buckets[i], *e.Value.(*nodeWithCookie[T]))
+		}
+	}
+	x.RUnlock()
+
+	return json.NewEncoder(w).Encode(s)
+}
+
+// Load replaces the routing table with one saved by Save,
+// nodes are admitted in their original order without pinging anyone,
+// so the table stays valid even if k or h changed.
+func (x *XTM[T]) Load(r io.Reader) (err error) {
+	var s xtmSnapshot[T]
+	err = json.NewDecoder(r).Decode(&s)
+	if err != nil {
+		return
+	}
+	if s.ID != x.id {
+		err = ErrIDMismatch
+		return
+	}
+	if len(s.Buckets) != x.bitSize {
+		err = fmt.Errorf("invalid bucket count %d", len(s.Buckets))
+		return
+	}
+
+	x.Lock()
+	defer x.Unlock()
+
+	for i := range x.buckets {
+		x.buckets[i] = newBucket[T]()
+	}
+	x.theta = -1
+
+	for _, nodes := range s.Buckets {
+		for _, n := range nodes {
+			x.addNeighbourLocked(n.N, n.Cookie, false)
+		}
+	}
+	return
+}
+
+// SaveFile saves the routing table to path, atomically and durably by renaming
+func (x *XTM[T]) SaveFile(path string) (err error) {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+
+	err = x.Save(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return
+	}
+
+	// make the rename durable
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	err = d.Sync()
+	d.Close()
+	return
+}
+
+// LoadFile loads the routing table saved by SaveFile
+func (x *XTM[T]) LoadFile(path string) (err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	err = x.Load(file)
+	return
+}
@@ -0,0 +1,84 @@
+package xorlayer
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestNodeID256(t *testing.T) {
+	id := NodeID256(sha256.Sum256([]byte("self")))
+	for i := 0; i < id.BitSize(); i++ {
+		assert.Equal(t, id.RandInBucket(i).Xor(id).LeadingZeros(), i)
+	}
+	assert.Equal(t, id.Xor(id).LeadingZeros(), 256)
+
+	text, err := id.MarshalText()
+	assert.NilError(t, err)
+	var id2 NodeID256
+	assert.NilError(t, id2.UnmarshalText(text))
+	assert.Equal(t, id, id2)
+}
+
+func TestSaveLoad(t *testing.T) {
+	const (
+		total = 300
+		k     = 8
+	)
+	ctx := context.Background()
+
+	net := NewSimNetwork[NodeID256]()
+	var nodes []*DHT[NodeID256]
+	for i := 0; i < total; i++ {
+		d := net.NewDHT(DHTConf[NodeID256]{ID: sha256.Sum256([]byte(fmt.Sprint(i))), K: k, H: 2})
+		if i > 0 {
+			assert.NilError(t, d.Bootstrap(ctx, []NodeID256{nodes[i/2].ID()}))
+		}
+		nodes = append(nodes, d)
+	}
+	defer func() {
+		for _, d := range nodes {
+			d.Close()
+		}
+	}()
+
+	var ids []NodeID256
+	for _, d := range nodes {
+		ids = append(ids, d.ID())
+	}
+	for i := 0; i < 20; i++ {
+		target := NodeID256(sha256.Sum256([]byte(fmt.Sprint("key", i))))
+		closest, err := nodes[i].Lookup(ctx, target)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, closest, xClosest(append([]NodeID256(nil), ids...), k, target))
+	}
+
+	// restart a node from the saved table
+	d := nodes[total-1]
+	path := filepath.Join(t.TempDir(), "table.json")
+	assert.NilError(t, d.XTM().SaveFile(path))
+
+	restarted := NewXTM[NodeID256](k, 2, d.ID(), nil)
+	assert.NilError(t, restarted.LoadFile(path))
+	assert.Equal(t, restarted.NeighbourCount(), d.XTM().NeighbourCount())
+	for i := 0; i < 20; i++ {
+		target := NodeID256(sha256.Sum256([]byte(fmt.Sprint("target", i))))
+		assert.Assert(t, reflect.DeepEqual(sorted(restarted.KClosest(target), target), sorted(d.XTM().KClosest(target), target)))
+	}
+
+	other := NewXTM[NodeID256](k, 2, nodes[0].ID(), nil)
+	assert.Equal(t, other.LoadFile(path), ErrIDMismatch)
+
+	_, err := os.Stat(path + ".tmp")
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func sorted(ns []NodeID256, target NodeID256) []NodeID256 {
+	return xClosest(ns, len(ns), target)
+}
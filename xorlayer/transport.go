@@ -0,0 +1,119 @@
+package xorlayer
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Transport sends rpcs from the local node to others,
+// it's also the Callback of the XTM of DHT
+type Transport[T any] interface {
+	Ping(ctx context.Context, to T) error
+	FindNode(ctx context.Context, to, target T) ([]T, error)
+	// FindValue returns the value if to has it, otherwise the nodes closest to key known by to
+	FindValue(ctx context.Context, to, key T) (value []byte, closest []T, err error)
+	Store(ctx context.Context, to, key T, value []byte) error
+}
+
+// ErrUnreachable when the remote node is down or unknown
+var ErrUnreachable = errors.New("node unreachable")
+
+// SimNetwork is an in-process network of DHT for tests
+type SimNetwork[T ID[T]] struct {
+	mu    sync.RWMutex
+	nodes map[T]*DHT[T]
+	down  map[T]bool
+}
+
+// NewSimNetwork is ctor for SimNetwork
+func NewSimNetwork[T ID[T]]() *SimNetwork[T] {
+	return &SimNetwork[T]{nodes: make(map[T]*DHT[T]), down: make(map[T]bool)}
+}
+
+// NewDHT creates a DHT attached to the network
+func (n *SimNetwork[T]) NewDHT(conf DHTConf[T]) *DHT[T] {
+	d := NewDHT[T](conf, &simTransport[T]{n: n, self: conf.ID})
+
+	n.mu.Lock()
+	n.nodes[conf.ID] = d
+	n.mu.Unlock()
+	return d
+}
+
+// SetDown makes the node unreachable or back
+func (n *SimNetwork[T]) SetDown(id T, down bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if down {
+		n.down[id] = true
+	} else {
+		delete(n.down, id)
+	}
+}
+
+func (n *SimNetwork[T]) get(id T) (d *DHT[T], err error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	d = n.nodes[id]
+	if d == nil || n.down[id] {
+		err = ErrUnreachable
+	}
+	return
+}
+
+type simTransport[T ID[T]] struct {
+	n    *SimNetwork[T]
+	self T
+}
+
+// rpc fails if either end is down
+func (t *simTransport[T]) remote(ctx context.Context, to T) (d *DHT[T], err error) {
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+	_, err = t.n.get(t.self)
+	if err != nil {
+		return
+	}
+	return t.n.get(to)
+}
+
+func (t *simTransport[T]) Ping(ctx context.Context, to T) (err error) {
+	d, err := t.remote(ctx, to)
+	if err != nil {
+		return
+	}
+	d.HandlePing(t.self)
+	return
+}
+
+func (t *simTransport[T]) FindNode(ctx context.Context, to, target T) (closest []T, err error) {
+	d, err := t.remote(ctx, to)
+	if err != nil {
+		return
+	}
+	closest = d.HandleFindNode(t.self, target)
+	return
+}
+
+func (t *simTransport[T]) FindValue(ctx context.Context, to, key T) (value []byte, closest []T, err error) {
+	d, err := t.remote(ctx, to)
+	if err != nil {
+		return
+	}
+	value, closest = d.HandleFindValue(t.self, key)
+	return
+}
+
+func (t *simTransport[T]) Store(ctx context.Context, to, key T, value []byte) (err error) {
+	d, err := t.remote(ctx, to)
+	if err != nil {
+		return
+	}
+	d.HandleStore(t.self, key, value)
+	return
+}
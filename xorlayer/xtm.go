@@ -2,84 +2,127 @@ package xorlayer
 
 import (
 	"context"
-	"math/bits"
 	"sync"
 	"time"
-	"unsafe"
 
-	"github.com/zhiqiangxu/util/sort"
+	"github.com/zhiqiangxu/util"
 )
 
-// NodeID can be replace by https://github.com/zhiqiangxu/gg
-type NodeID uint64
+// Callback is used by XTM
+type Callback[T any] interface {
+	Ping(ctx context.Context, nodeID T) error
+}
 
-const (
-	bitSize = int(unsafe.Sizeof(NodeID(0)) * 8)
-)
+// XTMConf for XTM
+type XTMConf struct {
+	// timeout of each ping, default 2s
+	PingTimeout time.Duration
+	// goroutines pinging, default 4
+	PingWorkers int
+	// pings queued beyond busy workers, more are dropped, default 64
+	PingQueue int
+	// size of the replacement cache per bucket, default k
+	ReplacementSize int
+}
 
-// Callback is used by XTM
-type Callback interface {
-	Ping(ctx context.Context, nodeID NodeID) error
+// XTMStats is the statistics about pings and evictions
+type XTMStats struct {
+	Pings        uint64
+	PingFailures uint64
+	// pings dropped since the queue is full
+	PingsDropped uint64
+	// nodes evicted since they didn't respond to ping
+	Evictions uint64
+	// nodes admitted from replacement caches
+	Replacements uint64
 }
 
-// XTM manages xor topology
-type XTM struct {
+// XTM manages xor topology of ids of type T
+type XTM[T ID[T]] struct {
 	sync.RWMutex
 	k       int
 	h       int
 	theta   int // for delimiting close region
-	id      NodeID
-	buckets []*bucket
-	cb      Callback
+	id      T
+	bitSize int
+	buckets []*bucket[T]
+	cb      Callback[T]
+	conf    XTMConf
+	pool    *util.WorkerPool // for pings, nil without cb
+	stats   XTMStats
 }
 
 // NewXTM is ctor for XTM
 // caller is responsible for dealing with duplicate NodeID
-func NewXTM(k, h int, id NodeID, cb Callback) *XTM {
-	buckets := make([]*bucket, bitSize)
+func NewXTM[T ID[T]](k, h int, id T, cb Callback[T]) *XTM[T] {
+	return NewXTMWithConf(k, h, id, cb, XTMConf{})
+}
+
+// NewXTMWithConf is ctor for XTM with conf
+func NewXTMWithConf[T ID[T]](k, h int, id T, cb Callback[T], conf XTMConf) *XTM[T] {
+	if conf.PingTimeout <= 0 {
+		conf.PingTimeout = 2 * time.Second
+	}
+	if conf.PingWorkers <= 0 {
+		conf.PingWorkers = 4
+	}
+	if conf.PingQueue <= 0 {
+		conf.PingQueue = 64
+	}
+	if conf.ReplacementSize <= 0 {
+		conf.ReplacementSize = k
+	}
+
+	buckets := make([]*bucket[T], id.BitSize())
 	for i := range buckets {
-		buckets[i] = newBucket()
+		buckets[i] = newBucket[T]()
+	}
+	x := &XTM[T]{k: k, h: h, theta: -1, id: id, bitSize: id.BitSize(), buckets: buckets, cb: cb, conf: conf}
+	if cb != nil {
+		x.pool = util.NewWorkerPoolWithConf(util.WorkerPoolConf{Size: conf.PingWorkers, QueueSize: conf.PingQueue, Policy: util.QueueReject})
 	}
-	x := &XTM{k: k, h: h, theta: -1, id: id, buckets: buckets, cb: cb}
 	return x
 }
 
+// Close stops pinging after queued pings are done
+func (x *XTM[T]) Close() {
+	if x.pool != nil {
+		x.pool.Close()
+	}
+}
+
 // AddNeighbours is batch for AddNeighbour
-func (x *XTM) AddNeighbours(ns []NodeID, cookies []uint64) {
+func (x *XTM[T]) AddNeighbours(ns []T, cookies []uint64) {
 	x.Lock()
-
-	var unlocked bool
+	defer x.Unlock()
 
 	for i, n := range ns {
-		unlocked = x.addNeighbourLocked(n, cookies[i])
-		if unlocked {
-			unlocked = false
-			x.Lock()
-		}
-	}
-
-	if !unlocked {
-		x.Unlock()
+		x.addNeighbourLocked(n, cookies[i], true)
 	}
 }
 
-// AddNeighbour tries to add NodeID with cookie to kbucket
-func (x *XTM) AddNeighbour(n NodeID, cookie uint64) {
+// AddNeighbour tries to add NodeID with cookie to kbucket, it never blocks on pings:
+// if the bucket is full, the node goes to the replacement cache of the bucket,
+// and the oldest node of the bucket is pinged asynchronously, to be replaced if it fails.
+func (x *XTM[T]) AddNeighbour(n T, cookie uint64) {
 	x.Lock()
+	defer x.Unlock()
 
-	if !x.addNeighbourLocked(n, cookie) {
-		x.Unlock()
-	}
+	x.addNeighbourLocked(n, cookie, true)
 }
 
-const (
-	pingTimeout = time.Second * 2
-)
+// Stats returns the statistics
+func (x *XTM[T]) Stats() XTMStats {
+	x.RLock()
+	defer x.RUnlock()
+
+	return x.stats
+}
 
-func (x *XTM) addNeighbourLocked(n NodeID, cookie uint64) (unlocked bool) {
+func (x *XTM[T]) addNeighbourLocked(n T, cookie uint64, ping bool) {
 	i := x.getBucketIdx(n)
 
-	if i >= bitSize {
+	if i >= x.bitSize {
 		return
 	}
 
@@ -94,21 +137,9 @@ func (x *XTM) addNeighbourLocked(n NodeID, cookie uint64) (unlocked bool) {
 		if bucket.size() < (x.k + x.h) {
 			bucket.insert(n, cookie)
 		} else {
-			if x.cb != nil {
-				oldest := bucket.oldest()
-				x.Unlock()
-				ctx, cancelFunc := context.WithTimeout(context.Background(), pingTimeout)
-				defer cancelFunc()
-
-				err := x.cb.Ping(ctx, oldest.N)
-				if err != nil {
-					x.Lock()
-					if bucket.remove(oldest.N, oldest.Cookie) {
-						bucket.insert(n, cookie)
-					}
-				} else {
-					unlocked = true
-				}
+			bucket.addReplacement(n, cookie, x.conf.ReplacementSize)
+			if ping {
+				x.pingOldestLocked(bucket)
 			}
 		}
 	} else {
@@ -120,7 +151,7 @@ func (x *XTM) addNeighbourLocked(n NodeID, cookie uint64) (unlocked bool) {
 		for {
 			if x.buckets[x.theta+1].size() >= (x.k + x.h) {
 				total := 0
-				for j := x.theta + 2; j < bitSize; j++ {
+				for j := x.theta + 2; j < x.bitSize; j++ {
 					total += x.buckets[j].size()
 				}
 				if total >= (x.k + x.h) {
@@ -135,21 +166,76 @@ func (x *XTM) addNeighbourLocked(n NodeID, cookie uint64) (unlocked bool) {
 		}
 
 	}
+}
+
+// pingOldestLocked pings the oldest node of bucket unless it's being pinged
+func (x *XTM[T]) pingOldestLocked(bucket *bucket[T]) {
+	if x.pool == nil || bucket.pinging {
+		return
+	}
+
+	oldest := bucket.oldest()
+	bucket.pinging = true
+	err := x.pool.Run(func() {
+		x.ping(bucket, oldest)
+	})
+	if err != nil {
+		bucket.pinging = false
+		x.stats.PingsDropped++
+	}
+}
+
+func (x *XTM[T]) ping(bucket *bucket[T], oldest nodeWithCookie[T]) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), x.conf.PingTimeout)
+	err := x.cb.Ping(ctx, oldest.N)
+	cancelFunc()
 
+	x.Lock()
+	defer x.Unlock()
+
+	bucket.pinging = false
+	x.stats.Pings++
+	if err == nil {
+		bucket.refresh(oldest.N)
+		return
+	}
+
+	x.stats.PingFailures++
+	if bucket.remove(oldest.N, oldest.Cookie) {
+		x.stats.Evictions++
+		x.replaceLocked(bucket)
+	}
+}
+
+// replaceLocked fills bucket with the most recent node in its replacement cache
+func (x *XTM[T]) replaceLocked(bucket *bucket[T]) (replaced bool) {
+	r, ok := bucket.popReplacement()
+	if ok {
+		bucket.insert(r.N, r.Cookie)
+		x.stats.Replacements++
+		replaced = true
+	}
 	return
 }
 
-func (x *XTM) delNeighbourLocked(n NodeID, cookie uint64) {
+func (x *XTM[T]) delNeighbourLocked(n T, cookie uint64) {
 	i := x.getBucketIdx(n)
-	if i >= bitSize {
+	if i >= x.bitSize {
 		return
 	}
 
-	x.buckets[i].remove(n, cookie)
+	bucket := x.buckets[i]
+	bucket.removeReplacement(n, cookie)
+	if !bucket.remove(n, cookie) {
+		return
+	}
 
 	if i <= x.theta {
+		if x.replaceLocked(bucket) {
+			return
+		}
 		// decrement theta if necessary
-		if x.buckets[i].size() < x.k {
+		if bucket.size() < x.k {
 			x.theta = i - 1
 		}
 	}
@@ -161,16 +247,13 @@ func (x *XTM) delNeighbourLocked(n NodeID, cookie uint64) {
 // 1 for all NodeID that's different from id from the second bit, all with the same prefix 2 bit (2^^62)
 // ...
 // 63 for all NodeID that's different from id from the 64th bit, all with the same prefix 64 bit(2^^0)
-// 64 means n == id
-func (x *XTM) getBucketIdx(n NodeID) int {
-	xor := uint64(n ^ x.id)
-	lz := bits.LeadingZeros64(xor)
-
-	return lz
+// 64, or BitSize() in general, means n == id
+func (x *XTM[T]) getBucketIdx(n T) int {
+	return n.Xor(x.id).LeadingZeros()
 }
 
 // DelNeighbours is batch for DelNeighbour
-func (x *XTM) DelNeighbours(ns []NodeID, cookies []uint64) {
+func (x *XTM[T]) DelNeighbours(ns []T, cookies []uint64) {
 	x.Lock()
 	defer x.Unlock()
 
@@ -180,15 +263,30 @@ func (x *XTM) DelNeighbours(ns []NodeID, cookies []uint64) {
 }
 
 // DelNeighbour by NodeID and cookie
-func (x *XTM) DelNeighbour(n NodeID, cookie uint64) {
+func (x *XTM[T]) DelNeighbour(n T, cookie uint64) {
 	x.Lock()
 	defer x.Unlock()
 
 	x.delNeighbourLocked(n, cookie)
 }
 
+// failNeighbour removes n after max consecutive failed rpcs to it,
+// so that a single timeout doesn't evict a live node
+func (x *XTM[T]) failNeighbour(n T, max int) {
+	x.Lock()
+	defer x.Unlock()
+
+	i := x.getBucketIdx(n)
+	if i >= x.bitSize {
+		return
+	}
+	if cookie, evict := x.buckets[i].fail(n, max); evict {
+		x.delNeighbourLocked(n, cookie)
+	}
+}
+
 // NeighbourCount returns total neighbour count
-func (x *XTM) NeighbourCount() (total int) {
+func (x *XTM[T]) NeighbourCount() (total int) {
 	x.RLock()
 	defer x.RUnlock()
 
@@ -199,17 +297,17 @@ func (x *XTM) NeighbourCount() (total int) {
 }
 
 // KClosest returns k-closest nodes to target
-func (x *XTM) KClosest(target NodeID) (ns []NodeID) {
+func (x *XTM[T]) KClosest(target T) (ns []T) {
 	x.RLock()
 	defer x.RUnlock()
 
-	ns = make([]NodeID, 0, x.k)
+	ns = make([]T, 0, x.k)
 
 	i := x.getBucketIdx(target)
-	if i >= bitSize {
+	if i >= x.bitSize {
 		ns = append(ns, x.id)
 		remain := x.k - 1
-		for j := bitSize - 1; j >= 0; j-- {
+		for j := x.bitSize - 1; j >= 0; j-- {
 			bucket := x.buckets[j]
 			ns = bucket.appendXClosest(ns, remain, target)
 			remain = x.k - len(ns)
@@ -227,27 +325,13 @@ func (x *XTM) KClosest(target NodeID) (ns []NodeID) {
 	}
 
 	// search i+1, i+2, ... etc
-	var right []NodeID
-	for j := i + 1; j < bitSize; j++ {
-		right = x.buckets[i].appendAll(right)
+	var right []T
+	for j := i + 1; j < x.bitSize; j++ {
+		right = x.buckets[j].appendAll(right)
 	}
 	right = append(right, x.id)
 
-	kclosest := sort.KSmallest(right, remain, func(j, k int) int {
-		dj := right[j] ^ target
-		dk := right[k] ^ target
-		switch {
-		case dj < dk:
-			return -1
-		case dj > dk:
-			return 1
-		}
-
-		return 0
-	}).([]NodeID)
-	for _, n := range kclosest {
-		ns = append(ns, n)
-	}
+	ns = append(ns, xClosest(right, remain, target)...)
 
 	remain = x.k - len(ns)
 	if remain == 0 {
@@ -1,8 +1,12 @@
 package xorlayer
 
 import (
+	"context"
+	"errors"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"sort"
 
@@ -50,3 +54,71 @@ func TestXTM(t *testing.T) {
 	expected = append(expected, neighbourSlice[0:k-1]...)
 	assert.Assert(t, reflect.DeepEqual(kclosest, expected))
 }
+
+type blockingPinger struct {
+	mu      sync.Mutex
+	pinged  []NodeID
+	replyCh chan error
+}
+
+func (p *blockingPinger) Ping(ctx context.Context, n NodeID) error {
+	p.mu.Lock()
+	p.pinged = append(p.pinged, n)
+	p.mu.Unlock()
+
+	select {
+	case err := <-p.replyCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestXTMAsyncAdmission(t *testing.T) {
+	p := &blockingPinger{replyCh: make(chan error)}
+	x := NewXTMWithConf[NodeID](2, 0, 0, p, XTMConf{PingTimeout: time.Hour, ReplacementSize: 2})
+	defer x.Close()
+
+	// bucket 0 becomes the far region limited to k+h
+	x.AddNeighbours([]NodeID{1 << 62, 1<<62 + 1, 1 << 63, 1<<63 + 1}, make([]uint64, 4))
+	assert.Equal(t, x.theta, 0)
+
+	// the bucket is full, adding never blocks on the pending ping
+	for i := 2; i < 6; i++ {
+		x.AddNeighbour(NodeID(1<<63)+NodeID(i), 0)
+	}
+	assert.Equal(t, x.buckets[0].size(), 2)
+	assert.Equal(t, x.buckets[0].rl.Len(), 2)
+
+	// the oldest fails, the most recent replacement takes its place
+	p.replyCh <- errors.New("timeout")
+	waitStats := func(pings uint64) XTMStats {
+		for {
+			stats := x.Stats()
+			if stats.Pings == pings {
+				return stats
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	stats := waitStats(1)
+	assert.Equal(t, stats.Evictions, uint64(1))
+	assert.Equal(t, stats.Replacements, uint64(1))
+	p.mu.Lock()
+	assert.DeepEqual(t, p.pinged, []NodeID{1 << 63})
+	p.mu.Unlock()
+	assert.Assert(t, x.buckets[0].refresh(1<<63+5))
+
+	// the oldest responds and stays
+	x.AddNeighbour(NodeID(1<<63+6), 0)
+	p.replyCh <- nil
+	stats = waitStats(2)
+	assert.Equal(t, stats.Evictions, uint64(1))
+	assert.Assert(t, x.buckets[0].refresh(1<<63+1))
+
+	// deleting a node admits a replacement too
+	x.DelNeighbour(1<<63+1, 0)
+	assert.Equal(t, x.Stats().Replacements, uint64(2))
+	assert.Equal(t, x.buckets[0].size(), 2)
+	assert.Equal(t, x.theta, 0)
+}